@@ -3,7 +3,7 @@ package ante
 import (
 	"fmt"
 	"io"
-	"maps"
+	"slices"
 	"strings"
 
 	"golang.org/x/net/html"
@@ -19,6 +19,36 @@ type AnteTemplate interface {
 	FillIn(w io.Writer, ds DataSource) error
 }
 
+// Emptier is a DataSource that can have nothing in it, like a query
+// without rows, which data-if takes as false.
+type Emptier interface {
+	Empty() bool
+}
+
+// ItemError is the errors of filling in the data-item, or the loop, Item.
+// Items inside it have ItemErrors of their own in Errs.
+type ItemError struct {
+	Item string
+	Errs []error
+}
+
+func (ie *ItemError) Error() string {
+	return fmt.Sprintf("'%s' %v", ie.Item, ie.Errs)
+}
+func (ie *ItemError) Unwrap() []error {
+	return ie.Errs
+}
+
+// fillInErrors are the errors of the blocks of a template.
+type fillInErrors []error
+
+func (fe fillInErrors) Error() string {
+	return fmt.Sprintf("errors while filling in %v", []error(fe))
+}
+func (fe fillInErrors) Unwrap() []error {
+	return fe
+}
+
 type anteTemplate struct {
 	blocks []AnteTemplate
 }
@@ -26,6 +56,9 @@ type dsTemplate struct {
 	isALoop bool
 	item    string
 	blocks  []AnteTemplate
+	// empty is the data-empty sibling of a loop, filled in instead when
+	// the loop has no rows.
+	empty AnteTemplate
 }
 
 type stringTemplate struct {
@@ -33,13 +66,44 @@ type stringTemplate struct {
 }
 type attrsTemplate struct {
 	tagname   string
-	attrs     map[string]string
+	attrs     []tagAttr
 	slash     string
-	dataAttrs map[string]string
+	dataAttrs []tagAttr
+}
+
+// tagAttr is an attribute of a tag, the attributes are kept in the order
+// of the template so a tag is always built the same way.
+type tagAttr struct {
+	key, val string
+}
+
+// setAttr sets key to val in attrs, after the others if it is new.
+func setAttr(attrs []tagAttr, key, val string) []tagAttr {
+	for i := range attrs {
+		if attrs[i].key == key {
+			attrs[i].val = val
+			return attrs
+		}
+	}
+	return append(attrs, tagAttr{key, val})
 }
 
 type substituteTemplate struct {
-	block string
+	block  string
+	escape escaper
+}
+
+type htmlTemplate struct {
+	block     string
+	sanitizer Sanitizer
+}
+
+// condTemplate fills in its element only when its key is truthy, or
+// when it isn't for data-unless.
+type condTemplate struct {
+	key    string
+	unless bool
+	block  AnteTemplate
 }
 
 type templateLevel interface {
@@ -70,14 +134,14 @@ type subTemplate struct {
 
 func (st *subTemplate) onError(templates []AnteTemplate) AnteTemplate {
 	templates = append(templates, &stringTemplate{"</" + st.tagName + ">"})
-	return &dsTemplate{st.isALoop, st.dataItem, templates}
+	return &dsTemplate{st.isALoop, st.dataItem, templates, nil}
 }
 func (st *subTemplate) onEndTag(templates []AnteTemplate, endTag string) (AnteTemplate, []AnteTemplate) {
 	templates = append(templates, &stringTemplate{"</" + endTag + ">"})
 	if st.tagName == endTag {
 		st.depth -= 1
 		if st.depth < 1 {
-			return &dsTemplate{st.isALoop, st.dataItem, templates}, nil
+			return &dsTemplate{st.isALoop, st.dataItem, templates, nil}, nil
 		}
 	}
 	return nil, templates
@@ -89,14 +153,26 @@ func (st *subTemplate) updateTagName(startTagName string) {
 	}
 }
 
+// tokenizer carries the settings the parse needs along with the html tokens.
+type tokenizer struct {
+	*html.Tokenizer
+	sanitizer Sanitizer
+}
+
 func NewAnteTemplate(tmplt string) AnteTemplate {
-	z := html.NewTokenizer(strings.NewReader(tmplt))
+	return NewAnteTemplateWithSanitizer(tmplt, DefaultAllowList)
+}
+
+// NewAnteTemplateWithSanitizer parses tmplt, cleaning data-html values with
+// sanitizer. A nil sanitizer trusts data-html values completely.
+func NewAnteTemplateWithSanitizer(tmplt string, sanitizer Sanitizer) AnteTemplate {
+	z := &tokenizer{html.NewTokenizer(strings.NewReader(tmplt)), sanitizer}
 	var templates []AnteTemplate
 
 	return parseTemplate(z, topLevel(false), templates)
 }
 
-func parseTemplate(z *html.Tokenizer, level templateLevel, templates []AnteTemplate) AnteTemplate {
+func parseTemplate(z *tokenizer, level templateLevel, templates []AnteTemplate) AnteTemplate {
 	for {
 		tt := z.Next()
 		switch tt {
@@ -119,22 +195,35 @@ func parseTemplate(z *html.Tokenizer, level templateLevel, templates []AnteTempl
 			}
 		case html.SelfClosingTagToken, html.StartTagToken:
 			tagName, hasAttrs := z.TagName()
-			level.updateTagName(string(tagName[:]))
-			templates = recurseIt(hasAttrs, string(tagName[:]), tt == html.SelfClosingTagToken, templates, z)
+			var nested bool
+			templates, nested = recurseIt(hasAttrs, string(tagName[:]), tt == html.SelfClosingTagToken, templates, z)
+			// a nested template reads its own end tag
+			if !nested {
+				level.updateTagName(string(tagName[:]))
+			}
 		}
 	}
 }
 
-func newItem(initTemplate AnteTemplate, tagName string, dataItem string, z *html.Tokenizer, isALoop bool) AnteTemplate {
+func newItem(initTemplate AnteTemplate, tagName string, dataItem string, z *tokenizer, isALoop bool) AnteTemplate {
 	templates := []AnteTemplate{initTemplate}
 	level := &subTemplate{tagName, isALoop, dataItem, 1}
 	return parseTemplate(z, level, templates)
 }
 
-func newField(initTemplate AnteTemplate, tagName string, dataField string, z *html.Tokenizer) AnteTemplate {
+// newBlock groups an element with what is in it, filled in from the same
+// data source.
+func newBlock(initTemplate AnteTemplate, tagName string, isSelfClosing bool, z *tokenizer) AnteTemplate {
+	if isSelfClosing || voidElements[tagName] {
+		return initTemplate
+	}
+	return newItem(initTemplate, tagName, "", z, false)
+}
+
+func newField(initTemplate AnteTemplate, tagName string, field AnteTemplate, z *tokenizer) AnteTemplate {
 	var templates []AnteTemplate
 	templates = append(templates, initTemplate)
-	templates = append(templates, &substituteTemplate{dataField})
+	templates = append(templates, field)
 	templates = append(templates, &stringTemplate{"</" + tagName + ">"})
 	for {
 		tt := z.Next()
@@ -156,13 +245,19 @@ func newField(initTemplate AnteTemplate, tagName string, dataField string, z *ht
 
 }
 
-func recurseIt(hasAttrs bool, startTagName string, isSelfClosing bool, templates []AnteTemplate, z *html.Tokenizer) []AnteTemplate {
+// recurseIt adds the template for an element, and whether it is a nested
+// template that read the rest of the element.
+func recurseIt(hasAttrs bool, startTagName string, isSelfClosing bool, templates []AnteTemplate, z *tokenizer) ([]AnteTemplate, bool) {
 
 	newdataField := ""
 	newdataItem := ""
+	newdataHTML := ""
 	dataRepeating := ""
-	dataAttrs := make(map[string]string)
-	allAttrs := make(map[string]string)
+	dataIf, hasIf := "", false
+	dataUnless, hasUnless := "", false
+	hasEmpty := false
+	isRaw := false
+	var dataAttrs, allAttrs []tagAttr
 	for hasAttrs {
 		var bkey, bval []byte
 		bkey, bval, hasAttrs = z.TagAttr()
@@ -173,18 +268,28 @@ func recurseIt(hasAttrs bool, startTagName string, isSelfClosing bool, templates
 			newdataField = val
 		case "data-item":
 			newdataItem = val
+		case "data-html":
+			newdataHTML = val
 		case "data-repeating":
 			dataRepeating = val
+		case "data-raw":
+			isRaw = true
+		case "data-if":
+			dataIf, hasIf = val, true
+		case "data-unless":
+			dataUnless, hasUnless = val, true
+		case "data-empty":
+			hasEmpty = true
 		default:
 			if attr, hasPrefix := strings.CutPrefix(key, "data-attr-"); hasPrefix {
-				dataAttrs[attr] = val
+				dataAttrs = setAttr(dataAttrs, attr, val)
 			}
 		}
-		allAttrs[key] = val
+		allAttrs = setAttr(allAttrs, key, val)
 	}
 
 	slash := "/"
-	if !isSelfClosing || newdataItem != "" || newdataField != "" {
+	if !isSelfClosing || newdataItem != "" || newdataField != "" || newdataHTML != "" {
 		slash = ""
 	}
 
@@ -194,18 +299,68 @@ func recurseIt(hasAttrs bool, startTagName string, isSelfClosing bool, templates
 	} else {
 		initTemplate = &attrsTemplate{startTagName, allAttrs, slash, dataAttrs}
 	}
+	var element AnteTemplate
 	if newdataItem != "" || dataRepeating != "" {
-		templates = append(templates, newItem(initTemplate, startTagName, newdataItem, z, dataRepeating != ""))
+		element = newItem(initTemplate, startTagName, newdataItem, z, dataRepeating != "")
 	} else if newdataField != "" {
-		templates = append(templates, newField(initTemplate, startTagName, newdataField, z))
+		escape := escaperForElement(startTagName)
+		if isRaw {
+			escape = escapeRaw
+		}
+		element = newField(initTemplate, startTagName, &substituteTemplate{newdataField, escape}, z)
+	} else if newdataHTML != "" {
+		element = newField(initTemplate, startTagName, &htmlTemplate{newdataHTML, z.sanitizer}, z)
+	} else if hasIf || hasUnless || hasEmpty {
+		element = newBlock(initTemplate, startTagName, isSelfClosing, z)
 	} else {
-		templates = append(templates, initTemplate)
+		element = initTemplate
+	}
+	nested := element != initTemplate
+	if hasIf {
+		element = &condTemplate{dataIf, false, element}
+	}
+	if hasUnless {
+		element = &condTemplate{dataUnless, true, element}
 	}
-	return templates
+	if hasEmpty {
+		// a data-empty without a loop before it has nothing to stand in
+		// for, so it is dropped
+		if loop := lastLoop(templates); loop != nil {
+			loop.empty = element
+		}
+		return templates, nested
+	}
+	return append(templates, element), nested
+}
+
+// lastLoop is the data-repeating element just before the next one, if
+// there is one, past any white space. The loop can have a data-if or
+// data-unless of its own.
+func lastLoop(templates []AnteTemplate) *dsTemplate {
+	for i := len(templates) - 1; i >= 0; i-- {
+		t := templates[i]
+		for cond, ok := t.(*condTemplate); ok; cond, ok = t.(*condTemplate) {
+			t = cond.block
+		}
+		switch t := t.(type) {
+		case *stringTemplate:
+			if strings.TrimSpace(t.block) != "" {
+				return nil
+			}
+		case *dsTemplate:
+			if t.isALoop && t.empty == nil {
+				return t
+			}
+			return nil
+		default:
+			return nil
+		}
+	}
+	return nil
 }
 
 func attr(key, val string) string {
-	return key + "='" + val + "'"
+	return key + "='" + html.EscapeString(val) + "'"
 }
 
 /* run time below */
@@ -219,22 +374,37 @@ func (at *anteTemplate) FillIn(w io.Writer, ds DataSource) error {
 		}
 	}
 	if len(errs) > 0 {
-		return fmt.Errorf("errors while filling in %v", errs)
+		return fillInErrors(errs)
 	}
 	return nil
 }
 
 func (at *dsTemplate) fillInLoop(w io.Writer, ds DataSource) []error {
 	if ds == nil {
+		if at.empty != nil {
+			return at.fillInEmpty(w, nothingDS{})
+		}
 		return []error{fmt.Errorf(" no loop for '%v'", at.item)}
 	}
 	var errs []error
+	rows := 0
 	for innerDs := ds.GetNext(); innerDs != nil; innerDs = ds.GetNext() {
 		errs = at.fillInOnce(w, ds, innerDs, errs)
+		rows++
+	}
+	if rows == 0 && at.empty != nil {
+		return at.fillInEmpty(w, ds)
 	}
 	return errs
 }
 
+func (at *dsTemplate) fillInEmpty(w io.Writer, ds DataSource) []error {
+	if err := at.empty.FillIn(w, ds); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
 func (at *dsTemplate) fillInOnce(w io.Writer, ds DataSource, innerDs DataSource, errs []error) []error {
 	for _, block := range at.blocks {
 		err := block.FillIn(w, innerDs)
@@ -250,14 +420,17 @@ func (at *dsTemplate) fillIn(w io.Writer, ds DataSource) []error {
 		return at.fillInLoop(w, ds)
 	} else {
 		var errs []error
-		innerDs := ds.GetDS(at.item)
+		innerDs := ds
+		if at.item != "" {
+			innerDs = ds.GetDS(at.item)
+		}
 		return at.fillInOnce(w, ds, innerDs, errs)
 	}
 }
 func (at *dsTemplate) FillIn(w io.Writer, ds DataSource) error {
 	errs := at.fillIn(w, ds)
 	if len(errs) > 0 {
-		return fmt.Errorf("'%s' %v", at.item, errs)
+		return &ItemError{at.item, errs}
 	}
 	return nil
 }
@@ -268,22 +441,58 @@ func (at *stringTemplate) FillIn(w io.Writer, ds DataSource) error {
 }
 
 func (at *substituteTemplate) FillIn(w io.Writer, ds DataSource) error {
-	_, err := w.Write([]byte(ds.Get(at.block)))
+	_, err := w.Write([]byte(at.escape(ds.Get(at.block))))
+	return err
+}
+
+func (at *htmlTemplate) FillIn(w io.Writer, ds DataSource) error {
+	fragment := ds.Get(at.block)
+	if at.sanitizer != nil {
+		var err error
+		if fragment, err = at.sanitizer.Sanitize(fragment); err != nil {
+			return fmt.Errorf("'%s' %v", at.block, err)
+		}
+	}
+	_, err := w.Write([]byte(fragment))
 	return err
 }
 
-func (at *attrsTemplate) getReplacedAttrs(ds DataSource) map[string]string {
-	myAttrs := maps.Clone(at.attrs)
-	for attr, key := range at.dataAttrs {
-		myAttrs[attr] = ds.Get(key)
+// truthy is whether key has a value in ds, or a data source that isn't
+// an empty Emptier.
+func truthy(ds DataSource, key string) bool {
+	if ds == nil {
+		return false
+	}
+	if ds.Get(key) != "" {
+		return true
+	}
+	inner := ds.GetDS(key)
+	if inner == nil {
+		return false
+	}
+	e, ok := inner.(Emptier)
+	return !ok || !e.Empty()
+}
+
+func (at *condTemplate) FillIn(w io.Writer, ds DataSource) error {
+	if truthy(ds, at.key) == at.unless {
+		return nil
+	}
+	return at.block.FillIn(w, ds)
+}
+
+func (at *attrsTemplate) getReplacedAttrs(ds DataSource) []tagAttr {
+	myAttrs := slices.Clone(at.attrs)
+	for _, dataAttr := range at.dataAttrs {
+		myAttrs = setAttr(myAttrs, dataAttr.key, filterForAttr(dataAttr.key)(ds.Get(dataAttr.val)))
 	}
 	return myAttrs
 }
 
-func buildTag(tagname string, myAttrs map[string]string, slash string) string {
+func buildTag(tagname string, myAttrs []tagAttr, slash string) string {
 	attrs := []string{tagname}
-	for key, val := range myAttrs {
-		attrs = append(attrs, attr(key, val))
+	for _, a := range myAttrs {
+		attrs = append(attrs, attr(a.key, a.val))
 	}
 	return strings.Join(attrs, " ") + slash
 }
@@ -3,6 +3,8 @@ package ante_test
 import (
 	"alesgaroth.com/anterior/ante"
 	"bytes"
+	"context"
+	"errors"
 	"slices"
 	"strconv"
 	"strings"
@@ -72,6 +74,10 @@ func TestMissingList(t *testing.T) {
 	if !strings.Contains(err.Error(), "list") {
 		t.Errorf("expected an error containing 'list' got %v", err)
 	}
+	var itemErr *ante.ItemError
+	if !errors.As(err, &itemErr) || itemErr.Item != "list" {
+		t.Errorf("expected an ItemError of 'list' got %#v", err)
+	}
 }
 
 func TestItem(t *testing.T) {
@@ -118,8 +124,244 @@ func TestLoopy(t *testing.T) {
 func TestAttr(t *testing.T) {
 	template := "<a data-attr-href='foo'>Bar</a>"
 	expected := "<a data-attr-href='foo' href='Baz'>Bar</a>"
-	expected2 := "<a href='Baz' data-attr-href='foo'>Bar</a>"
-	testIt2(t, ds, template, []string{expected, expected2})
+	testIt(t, ds, template, expected)
+}
+
+func TestAttrsKeepTheirOrder(t *testing.T) {
+	// a map of attributes would come out in a different order now and then
+	for range 20 {
+		testIt(t, ds, "<a class='post' id='p7' rel='next' title='Next'>Bar</a>", "<a class='post' id='p7' rel='next' title='Next'>Bar</a>")
+		testIt(t, ds, "<a class='post' href='#' data-attr-href='foo' id='p7'>Bar</a>", "<a class='post' href='Baz' data-attr-href='foo' id='p7'>Bar</a>")
+	}
+}
+
+var nastyds = &MapDataSource{
+	map[string]string{
+		"body":  "<script>alert('hi')</script>",
+		"quote": "it's",
+		"link":  "javascript:alert(1)",
+		"good":  "https://alesgaroth.com/?a=1&b=2",
+		"style": "background: url(evil)",
+	},
+}
+
+func TestFieldIsEscaped(t *testing.T) {
+	template := "<p data-field='body'>Bar</p>"
+	expected := "<p data-field='body'>&lt;script&gt;alert(&#39;hi&#39;)&lt;/script&gt;</p>"
+	testIt(t, nastyds, template, expected)
+}
+
+func TestRawFieldIsNotEscaped(t *testing.T) {
+	template := "<div data-raw data-field='body'>Bar</div>"
+	expected := "<div data-raw='' data-field='body'><script>alert('hi')</script></div>"
+	testIt(t, nastyds, template, expected)
+}
+
+func TestScriptFieldIsAString(t *testing.T) {
+	template := "<script data-field='quote'></script>"
+	expected := `<script data-field='quote'>"it\u0027s"</script>`
+	testIt(t, nastyds, template, expected)
+}
+
+func TestAttrIsEscaped(t *testing.T) {
+	template := "<a data-attr-title='quote'>Bar</a>"
+	expected := "<a data-attr-title='quote' title='it&#39;s'>Bar</a>"
+	testIt(t, nastyds, template, expected)
+}
+
+func TestURLAttrIsFiltered(t *testing.T) {
+	template := "<a data-attr-href='link'>Bar</a>"
+	expected := "<a data-attr-href='link' href='#ZgotmplZ'>Bar</a>"
+	testIt(t, nastyds, template, expected)
+
+	template = "<a data-attr-href='good'>Bar</a>"
+	expected = "<a data-attr-href='good' href='https://alesgaroth.com/?a=1&amp;b=2'>Bar</a>"
+	testIt(t, nastyds, template, expected)
+}
+
+func TestSrcdocAttrIsEscaped(t *testing.T) {
+	template := "<iframe data-attr-srcdoc='body'></iframe>"
+	expected := "<iframe data-attr-srcdoc='body' srcdoc='&amp;lt;script&amp;gt;alert(&amp;#39;hi&amp;#39;)&amp;lt;/script&amp;gt;'></iframe>"
+	testIt(t, nastyds, template, expected)
+}
+
+func TestNamespacedURLAttrIsFiltered(t *testing.T) {
+	template := "<use data-attr-xlink:href='link'/>"
+	expected := "<use data-attr-xlink:href='link' xlink:href='#ZgotmplZ'/>"
+	testIt(t, nastyds, template, expected)
+}
+
+func TestSrcsetAttrIsFiltered(t *testing.T) {
+	ds := &MapDataSource{map[string]string{"srcset": "small.jpg 480w, javascript:alert(1) 1080w"}}
+	template := "<img data-attr-srcset='srcset'/>"
+	expected := "<img data-attr-srcset='srcset' srcset='small.jpg 480w, #ZgotmplZ 1080w'/>"
+	testIt(t, ds, template, expected)
+}
+
+func TestStyleAttrIsFiltered(t *testing.T) {
+	template := "<p data-attr-style='style'>Bar</p>"
+	expected := "<p data-attr-style='style' style='ZgotmplZ'>Bar</p>"
+	testIt(t, nastyds, template, expected)
+}
+
+func TestEventAttrIsAString(t *testing.T) {
+	template := "<p data-attr-onclick='quote'>Bar</p>"
+	expected := "<p data-attr-onclick='quote' onclick='&#34;it\\u0027s&#34;'>Bar</p>"
+	testIt(t, nastyds, template, expected)
+}
+
+var htmlds = &MapDataSource{
+	map[string]string{
+		"body": "<p onclick='evil()'>Hi <b>there</b><script>alert(1)</script><a href='javascript:alert(1)'>x</a></p><marquee>old</marquee>",
+	},
+}
+
+func TestHTMLIsSanitized(t *testing.T) {
+	template := "<div data-html='body'>Bar</div>"
+	expected := "<div data-html='body'><p>Hi <b>there</b><a href='#ZgotmplZ'>x</a></p>old</div>"
+	testIt(t, htmlds, template, expected)
+}
+
+func TestHTMLAllowList(t *testing.T) {
+	template := "<div data-html='body'>Bar</div>"
+	expected := "<div data-html='body'>Hi <b>there</b>xold</div>"
+	tmplt := ante.NewAnteTemplateWithSanitizer(template, ante.AllowList{"b": {}})
+	output := &bytes.Buffer{}
+	if err := tmplt.FillIn(output, htmlds); err != nil {
+		t.Error(err)
+	}
+	if output.String() != expected {
+		t.Errorf("template output does not match : got %v expected %v", output, expected)
+	}
+}
+
+func TestHTMLWithoutSanitizer(t *testing.T) {
+	template := "<div data-html='body'/>"
+	expected := "<div data-html='body'>" + htmlds.mp["body"] + "</div>"
+	tmplt := ante.NewAnteTemplateWithSanitizer(template, nil)
+	output := &bytes.Buffer{}
+	if err := tmplt.FillIn(output, htmlds); err != nil {
+		t.Error(err)
+	}
+	if output.String() != expected {
+		t.Errorf("template output does not match : got %v expected %v", output, expected)
+	}
+}
+
+type EmptyDataSource struct {
+	MapDataSource
+}
+
+func (EmptyDataSource) Empty() bool {
+	return true
+}
+
+func TestIf(t *testing.T) {
+	ds := &MapDataSourceDataSource{
+		map[string]ante.DataSource{"comments": &EmptyDataSource{}, "user": &MapDataSource{}},
+		map[string]string{"title": "Hello"},
+	}
+	template := "<p data-if='title'>has a title</p><p data-if='missing'>missing</p><p data-if='comments'>comments</p><a data-if='user' href='/edit'>edit</a><img data-if='missing' src='x.png'><br data-if='title'/><p>after</p>"
+	expected := "<p data-if='title'>has a title</p><a data-if='user' href='/edit'>edit</a><br data-if='title'/><p>after</p>"
+	testIt(t, ds, template, expected)
+}
+
+func TestUnless(t *testing.T) {
+	ds := &MapDataSourceDataSource{
+		map[string]ante.DataSource{"comments": &EmptyDataSource{}},
+		map[string]string{"title": "Hello"},
+	}
+	template := "<div><p data-unless='comments'>no comments yet</p><p data-unless='title'>untitled</p><b data-unless='missing' data-field='title'>Title</b></div>"
+	expected := "<div><p data-unless='comments'>no comments yet</p><b data-unless='missing' data-field='title'>Hello</b></div>"
+	testIt(t, ds, template, expected)
+}
+
+func TestNestedIf(t *testing.T) {
+	ds := &MapDataSourceDataSource{nil, map[string]string{"title": "Hello"}}
+	template := "<div data-if='title'><div data-if='missing'><p>hidden</p></div><p>shown</p></div>"
+	expected := "<div data-if='title'><p>shown</p></div>"
+	testIt(t, ds, template, expected)
+}
+
+func TestNestedSameTag(t *testing.T) {
+	// the inner div doesn't close the outer one
+	ds := &MapDataSourceDataSource{map[string]ante.DataSource{"post": &MapDataSource{map[string]string{"title": "Hello"}}}, nil}
+	template := "<div data-item='post'><div data-field='title'></div><p>after</p></div><p>end</p>"
+	expected := "<div data-item='post'><div data-field='title'>Hello</div><p>after</p></div><p>end</p>"
+	testIt(t, ds, template, expected)
+}
+
+func TestEmptyLoop(t *testing.T) {
+	template := "<ul data-item='list'>\n<li data-repeating='true'><span data-field='foo'></span></li>\n<li data-empty='true'>Nothing yet</li></ul>"
+	empty := &MapDataSourceDataSource{map[string]ante.DataSource{"list": &ListDataSource{}}, nil}
+	// the placeholder takes the place of the loop
+	testIt(t, empty, template, "<ul data-item='list'>\n<li data-empty='true'>Nothing yet</li>\n</ul>")
+
+	list := &ListDataSource{[]ante.DataSource{&MapDataSource{map[string]string{"foo": "Baz"}}}, 0}
+	full := &MapDataSourceDataSource{map[string]ante.DataSource{"list": list}, nil}
+	testIt(t, full, template, "<ul data-item='list'>\n<li data-repeating='true'><span data-field='foo'>Baz</span></li>\n</ul>")
+}
+
+func TestEmptyLoopWithoutDataSource(t *testing.T) {
+	template := "<ul data-item='list'><li data-repeating='true'>row</li><li data-empty='true'>Nothing yet</li></ul>"
+	if err := testIt(t, ds, template, "<ul data-item='list'><li data-empty='true'>Nothing yet</li></ul>"); err != nil {
+		t.Errorf("expected no error for a missing loop with data-empty got %v", err)
+	}
+}
+
+func TestEmptyConditionalLoop(t *testing.T) {
+	template := "<ul data-item='list'><li data-repeating='true' data-if='count'>row</li><li data-empty='true'>Nothing yet</li></ul>"
+	empty := &MapDataSourceDataSource{map[string]ante.DataSource{"list": &ListDataSource{}}, nil}
+	testIt(t, empty, template, "<ul data-item='list'><li data-empty='true'>Nothing yet</li></ul>")
+	list := &ListDataSource{[]ante.DataSource{&MapDataSource{map[string]string{"foo": "Baz"}}}, 0}
+	full := &MapDataSourceDataSource{map[string]ante.DataSource{"list": list}, nil}
+	testIt(t, full, template, "<ul data-item='list'><li data-repeating='true' data-if='count'>row</li></ul>")
+}
+
+func TestStrayEmptyIsDropped(t *testing.T) {
+	template := "<ul data-item='list'><li data-empty='true'>Nothing yet</li><li>after</li></ul><p data-empty='true'>Nothing</p>"
+	full := &MapDataSourceDataSource{map[string]ante.DataSource{"list": &ListDataSource{}}, nil}
+	testIt(t, full, template, "<ul data-item='list'><li>after</li></ul>")
+}
+
+type ctxKey struct{}
+
+type ContextDataSource struct {
+	MapDataSourceDataSource
+	got any
+}
+
+func (cds *ContextDataSource) GetDSContext(ctx context.Context, key string) ante.DataSource {
+	cds.got = ctx.Value(ctxKey{})
+	return cds.GetDS(key)
+}
+
+func TestContextIsPassedOn(t *testing.T) {
+	inner := &ContextDataSource{MapDataSourceDataSource{nil, map[string]string{"foo": "Baz"}}, nil}
+	outer := &MapDataSourceDataSource{map[string]ante.DataSource{"post": inner}, nil}
+	tmplt := ante.NewAnteTemplate("<div data-item='post'><p data-item='author'></p></div>")
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	if err := ante.FillIn(ctx, tmplt, &bytes.Buffer{}, outer); err != nil {
+		t.Fatal(err)
+	}
+	if inner.got != "request" {
+		t.Errorf("expected the context to reach a nested data source got %v", inner.got)
+	}
+}
+
+func TestCanceledFillIn(t *testing.T) {
+	list := &ListDataSource{[]ante.DataSource{&MapDataSource{map[string]string{"foo": "Baz"}}}, 0}
+	ds := &MapDataSourceDataSource{map[string]ante.DataSource{"list": list}, nil}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	output := &bytes.Buffer{}
+	err := ante.FillIn(ctx, ante.NewAnteTemplate("<p data-item='list'><span data-repeating='true' data-field='foo'></span></p>"), output, ds)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the fill in to be canceled got %v", err)
+	}
+	if strings.Contains(output.String(), "Baz") {
+		t.Errorf("expected no rows after the cancel got %v", output)
+	}
 }
 
 func testIt(t *testing.T, ds ante.DataSource, template string, expected string) error {
@@ -0,0 +1,97 @@
+package ante
+
+import (
+	"context"
+	"io"
+)
+
+// ContextDataSource is a DataSource whose data sources can take long to
+// get, like the results of a query, and stop when ctx is done.
+type ContextDataSource interface {
+	DataSource
+	GetDSContext(ctx context.Context, key string) DataSource
+}
+
+// ContextTemplate is an AnteTemplate that passes ctx to the data sources
+// it fills in from, and stops when ctx is done.
+type ContextTemplate interface {
+	AnteTemplate
+	FillInContext(ctx context.Context, w io.Writer, ds DataSource) error
+}
+
+// GetDS is ds.GetDS(key), with ctx when ds is a ContextDataSource.
+func GetDS(ctx context.Context, ds DataSource, key string) DataSource {
+	if cds, ok := ds.(ContextDataSource); ok {
+		return cds.GetDSContext(ctx, key)
+	}
+	return ds.GetDS(key)
+}
+
+// FillIn is t.FillIn(w, ds), with ctx when t is a ContextTemplate.
+func FillIn(ctx context.Context, t AnteTemplate, w io.Writer, ds DataSource) error {
+	if ct, ok := t.(ContextTemplate); ok {
+		return ct.FillInContext(ctx, w, ds)
+	}
+	return t.FillIn(w, ds)
+}
+
+// contextDS passes ctx to the data sources of ds, and has no more rows
+// once ctx is done.
+type contextDS struct {
+	ctx context.Context
+	ds  DataSource
+}
+
+func withContext(ctx context.Context, ds DataSource) DataSource {
+	if ds == nil {
+		return nil
+	}
+	return &contextDS{ctx, ds}
+}
+
+func (c *contextDS) Get(key string) string {
+	return c.ds.Get(key)
+}
+func (c *contextDS) GetDS(key string) DataSource {
+	if c.ctx.Err() != nil {
+		return nothingDS{}
+	}
+	return withContext(c.ctx, GetDS(c.ctx, c.ds, key))
+}
+func (c *contextDS) Empty() bool {
+	e, ok := c.ds.(Emptier)
+	return ok && e.Empty()
+}
+func (c *contextDS) GetNext() DataSource {
+	if c.ctx.Err() != nil {
+		return nil
+	}
+	return withContext(c.ctx, c.ds.GetNext())
+}
+
+func (at *anteTemplate) FillInContext(ctx context.Context, w io.Writer, ds DataSource) error {
+	if err := at.FillIn(w, withContext(ctx, ds)); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return ctx.Err()
+}
+
+// nothingDS has nothing in it. It is what is left of a data source once
+// its context is done, and what data-empty is filled in from without one.
+type nothingDS struct{}
+
+func (nothingDS) Get(key string) string {
+	return ""
+}
+func (nothingDS) GetDS(key string) DataSource {
+	return nothingDS{}
+}
+func (nothingDS) GetNext() DataSource {
+	return nil
+}
+func (nothingDS) Empty() bool {
+	return true
+}
@@ -0,0 +1,163 @@
+package ante
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// filteredValue replaces a value that is not safe in its context, the same
+// marker html/template uses so it is easy to grep for.
+const filteredValue = "ZgotmplZ"
+
+type escaper func(string) string
+
+func escapeRaw(s string) string {
+	return s
+}
+
+func escapeText(s string) string {
+	return html.EscapeString(s)
+}
+
+// escaperForElement picks how a data-field is escaped from the element it
+// fills in: script and style are raw text elements, everything else is a
+// text node.
+func escaperForElement(tagName string) escaper {
+	switch tagName {
+	case "script":
+		return escapeJSString
+	case "style":
+		return filterCSS
+	}
+	return escapeText
+}
+
+var urlAttrs = map[string]bool{
+	"action":     true,
+	"archive":    true,
+	"background": true,
+	"cite":       true,
+	"classid":    true,
+	"codebase":   true,
+	"data":       true,
+	"formaction": true,
+	"href":       true,
+	"icon":       true,
+	"longdesc":   true,
+	"manifest":   true,
+	"poster":     true,
+	"profile":    true,
+	"src":        true,
+	"usemap":     true,
+	"xmlns":      true,
+}
+
+// filterForAttr picks the filter for a data-attr-* value. The result is
+// still html escaped when the tag is built. Like html/template, a namespace
+// prefix, as in xlink:href, and a data- prefix don't change the filter.
+func filterForAttr(name string) escaper {
+	name = strings.ToLower(name)
+	if prefix, local, ok := strings.Cut(name, ":"); ok {
+		if prefix == "xmlns" {
+			return filterURL
+		}
+		name = local
+	}
+	name = strings.TrimPrefix(name, "data-")
+	switch {
+	case urlAttrs[name]:
+		return filterURL
+	case name == "srcset":
+		return filterSrcset
+	case name == "srcdoc":
+		// a document, escaped once more so the browser reads it as text
+		return escapeText
+	case name == "style":
+		return filterCSS
+	case strings.HasPrefix(name, "on"):
+		return escapeJSString
+	}
+	return escapeRaw
+}
+
+var safeSchemes = []string{"http", "https", "mailto", "tel"}
+
+// filterURL lets relative urls and urls with a known safe scheme through,
+// so values like javascript:alert(1) never end up in an href.
+func filterURL(s string) string {
+	trimmed := strings.TrimSpace(s)
+	if i := strings.IndexAny(trimmed, ":/?#"); i >= 0 && trimmed[i] == ':' {
+		scheme := strings.ToLower(trimmed[:i])
+		for _, safe := range safeSchemes {
+			if scheme == safe {
+				return s
+			}
+		}
+		return "#" + filteredValue
+	}
+	return s
+}
+
+// filterSrcset filters the url of each image candidate of a srcset, as in
+// "small.jpg 480w, large.jpg 1080w".
+func filterSrcset(s string) string {
+	candidates := strings.Split(s, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		if url := filterURL(fields[0]); url != fields[0] {
+			fields[0] = url
+			candidates[i] = " " + strings.Join(fields, " ")
+		}
+	}
+	return strings.TrimSpace(strings.Join(candidates, ","))
+}
+
+var unsafeCSS = []string{"<", ">", "\\", "/*", "*/", "expression", "url(", "@import", "javascript:", "behavior", "-moz-binding"}
+
+// filterCSS rejects css that could load resources or run script.
+func filterCSS(s string) string {
+	lower := strings.ToLower(s)
+	for _, bad := range unsafeCSS {
+		if strings.Contains(lower, bad) {
+			return filteredValue
+		}
+	}
+	return s
+}
+
+// escapeJSString quotes s as a javascript string literal that is safe
+// inside a script element and inside an event handler attribute.
+func escapeJSString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '"', '\'', '`', '<', '>', '&', '\u2028', '\u2029':
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			if r < ' ' {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package ante
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Sanitizer cleans up the html inserted by data-html.
+type Sanitizer interface {
+	Sanitize(fragment string) (string, error)
+}
+
+// AllowList is a Sanitizer that keeps the listed elements with the listed
+// attributes. Other elements are dropped, but their text is kept, except
+// for the ones in dropWithContent.
+type AllowList map[string][]string
+
+// DefaultAllowList is enough for the markup of a blog post.
+var DefaultAllowList = AllowList{
+	"a":          {"href", "title"},
+	"abbr":       {"title"},
+	"b":          {},
+	"blockquote": {"cite"},
+	"br":         {},
+	"code":       {"class"},
+	"dd":         {},
+	"del":        {},
+	"div":        {"class"},
+	"dl":         {},
+	"dt":         {},
+	"em":         {},
+	"figcaption": {},
+	"figure":     {},
+	"h1":         {"id"},
+	"h2":         {"id"},
+	"h3":         {"id"},
+	"h4":         {"id"},
+	"h5":         {"id"},
+	"h6":         {"id"},
+	"hr":         {},
+	"i":          {},
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        {},
+	"li":         {},
+	"ol":         {"start"},
+	"p":          {"class"},
+	"pre":        {"class"},
+	"q":          {"cite"},
+	"s":          {},
+	"small":      {},
+	"span":       {"class"},
+	"strong":     {},
+	"sub":        {},
+	"sup":        {},
+	"table":      {},
+	"tbody":      {},
+	"td":         {"colspan", "rowspan"},
+	"tfoot":      {},
+	"th":         {"colspan", "rowspan", "scope"},
+	"thead":      {},
+	"tr":         {},
+	"u":          {},
+	"ul":         {},
+}
+
+var dropWithContent = map[string]bool{
+	"embed":    true,
+	"iframe":   true,
+	"noscript": true,
+	"object":   true,
+	"script":   true,
+	"style":    true,
+	"template": true,
+}
+
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+func (al AllowList) Sanitize(fragment string) (string, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, node := range nodes {
+		al.render(&b, node)
+	}
+	return b.String(), nil
+}
+
+func (al AllowList) render(b *strings.Builder, node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		b.WriteString(html.EscapeString(node.Data))
+	case html.ElementNode:
+		if dropWithContent[node.Data] {
+			return
+		}
+		allowed, ok := al[node.Data]
+		if ok {
+			b.WriteString("<" + buildTag(node.Data, al.allowedAttrs(node, allowed), "") + ">")
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			al.render(b, child)
+		}
+		if ok && !voidElements[node.Data] {
+			b.WriteString("</" + node.Data + ">")
+		}
+	}
+}
+
+func (al AllowList) allowedAttrs(node *html.Node, allowed []string) []tagAttr {
+	var attrs []tagAttr
+	for _, a := range node.Attr {
+		if a.Namespace != "" {
+			continue
+		}
+		for _, name := range allowed {
+			if a.Key == name {
+				attrs = setAttr(attrs, name, filterForAttr(name)(a.Val))
+			}
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,34 @@
+// Command weblog lists the posts of the weblog database.
+package main
+
+import (
+	"fmt"
+	"log"
+
+	sqlrior "alesgaroth.com/anterior/rior/sql"
+)
+
+func main() {
+	sqlConn, err := sqlrior.NewRiorSqlConnection("alesgaroth", "localhost", "weblog")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sqlConn.Close()
+
+	err = sqlConn.Ping()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Successfully connected to PostgreSQL!")
+
+	rows, err := sqlConn.QueryRows("SELECT * FROM post")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for row := rows.GetNext(); row != nil; row = rows.GetNext() {
+		fmt.Printf("id : %v title %v body %v\n", row.Get("id"), row.Get("title"), row.Get("body"))
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
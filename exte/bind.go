@@ -0,0 +1,96 @@
+package exte
+
+import (
+	"fmt"
+	"strings"
+)
+
+type param struct {
+	name  string
+	value any
+	// uriVar is whether the param is a uri template variable, the only
+	// params bound to $n placeholders.
+	uriVar bool
+}
+
+// bindParams rewrites the :name placeholders in query that name one of
+// params into $n placeholders and returns the args to bind to them.
+// A query written with $n placeholders instead gets the uri template
+// variables of params, in order, since the other params, like the user
+// and the query string, come and go from one request to the next.
+// Placeholders inside quotes and :: casts are left alone.
+func bindParams(query string, params []param) (string, []any) {
+	var b strings.Builder
+	var args []any
+	index := make(map[string]int)
+	positional := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				i = len(query)
+				continue
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			b.WriteString("::")
+			i++
+		case c == ':':
+			name := identAt(query[i+1:])
+			value, ok := lookupParam(params, name)
+			if !ok {
+				b.WriteByte(c)
+				continue
+			}
+			n, seen := index[name]
+			if !seen {
+				args = append(args, value)
+				n = len(args)
+				index[name] = n
+			}
+			fmt.Fprintf(&b, "$%d", n)
+			i += len(name)
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			positional = true
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if positional && len(args) == 0 {
+		for _, p := range params {
+			if p.uriVar {
+				args = append(args, p.value)
+			}
+		}
+	}
+	return b.String(), args
+}
+
+func lookupParam(params []param, name string) (any, bool) {
+	if name == "" {
+		return nil, false
+	}
+	for _, p := range params {
+		if p.name == name {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+func identAt(s string) string {
+	end := 0
+	for end < len(s) && (s[end] == '_' || isDigit(s[end]) || ('a' <= s[end] && s[end] <= 'z') || ('A' <= s[end] && s[end] <= 'Z')) {
+		end++
+	}
+	return s[:end]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
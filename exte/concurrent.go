@@ -0,0 +1,94 @@
+package exte
+
+import (
+	"context"
+	"io"
+	"log"
+	"time"
+
+	"alesgaroth.com/anterior/ante"
+)
+
+const (
+	// DefaultWorkers is how many queries of a request CreateHandlers runs
+	// at once, for routes without workers.
+	DefaultWorkers = 4
+	// DefaultTimeout is how long the queries of a request can take, after
+	// that the page is a 500, for routes without a timeout.
+	DefaultTimeout = 30 * time.Second
+)
+
+func (ed Extedata) workers() int {
+	if ed.Workers == 0 {
+		return DefaultWorkers
+	}
+	return ed.Workers
+}
+
+func (ed Extedata) timeout() time.Duration {
+	if ed.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return ed.Timeout
+}
+
+type result struct {
+	i  int
+	ds ante.DataSource
+}
+
+// runAll runs queries with at most cq.Workers of them at a time, in order
+// when it is one. The results of the queries that are not done when ctx
+// is, are nil, and they are closed when they are done.
+func (cq *ExteQueryr) runAll(ctx context.Context, queries []Query, params []param) ([]ante.DataSource, error) {
+	results := make([]ante.DataSource, len(queries))
+	done := make(chan result, len(queries))
+	workers := make(chan struct{}, max(cq.Workers, 1))
+	started := make(chan int, len(queries))
+	go func() {
+		defer close(started)
+		for i, query := range queries {
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			started <- i
+			go func() {
+				defer func() { <-workers }()
+				done <- result{i, cq.run(ctx, query.Name, query.pagedSQL(params), params)}
+			}()
+		}
+	}()
+	for range queries {
+		select {
+		case r := <-done:
+			results[r.i] = r.ds
+		case <-ctx.Done():
+			for i, ds := range results {
+				if ds == nil {
+					log.Printf("query %s: %v", queries[i].Name, ctx.Err())
+				}
+			}
+			go closeLate(started, done, results)
+			return results, ctx.Err()
+		}
+	}
+	return results, nil
+}
+
+// closeLate closes the results of the queries that were started but were
+// not done in time, once they are.
+func closeLate(started chan int, done chan result, results []ante.DataSource) {
+	late := 0
+	for i := range started {
+		if results[i] == nil {
+			late++
+		}
+	}
+	for ; late > 0; late-- {
+		if c, ok := (<-done).ds.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package exte
+
+import (
+	"context"
+
+	"alesgaroth.com/anterior/ante"
+)
+
+// ContextDB is a DB whose queries stop when ctx is done, like a client
+// going away or the deadline of the request.
+type ContextDB interface {
+	QueryContext(ctx context.Context, query string, args ...any) ante.DataSource
+}
+
+// ExecContextDB is an ExecDB whose queries stop when ctx is done.
+type ExecContextDB interface {
+	ExecContext(ctx context.Context, query string, args ...any) error
+}
+
+// ContextAdapter makes db a ContextDB. When db isn't one already, its
+// queries don't start once ctx is done, but ctx can't stop them after.
+func ContextAdapter(db DB) ContextDB {
+	if cdb, ok := db.(ContextDB); ok {
+		return cdb
+	}
+	return contextAdapter{db}
+}
+
+type contextAdapter struct {
+	db DB
+}
+
+func (ca contextAdapter) QueryContext(ctx context.Context, query string, args ...any) ante.DataSource {
+	if ctx.Err() != nil {
+		return emptyDS(false)
+	}
+	if argsdb, ok := ca.db.(ArgsDB); ok {
+		return argsdb.QueryArgs(query, args...)
+	}
+	if len(args) > 0 {
+		return emptyDS(false)
+	}
+	return ca.db.Query(query)
+}
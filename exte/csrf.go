@@ -0,0 +1,123 @@
+package exte
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"alesgaroth.com/anterior/ante"
+)
+
+const (
+	// CSRFCookie holds the token of the browser.
+	CSRFCookie = "exte_csrf"
+	// CSRFField is the form field a form posts the token back in, and the
+	// key of the token in a template, as in
+	// <input type='hidden' name='csrf_token' data-attr-value='csrf_token'>
+	CSRFField = "csrf_token"
+	// CSRFHeader is where scripts can send the token instead.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+func (ed Extedata) checksCSRF() bool {
+	if ed.CSRF == nil {
+		return ed.method() != http.MethodGet
+	}
+	return *ed.CSRF
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// csrfToken is the csrf token of a request, the one of its session when
+// it has one. Otherwise it is made, and its cookie set, the first time a
+// template asks for it.
+type csrfToken struct {
+	rw    http.ResponseWriter
+	req   *http.Request
+	token string
+}
+
+func (t *csrfToken) get() string {
+	if s := sessionOf(t.req); s != nil {
+		return s.csrf
+	}
+	if t.token == "" {
+		if cookie, err := t.req.Cookie(CSRFCookie); err == nil && cookie.Value != "" {
+			t.token = cookie.Value
+		} else {
+			t.token = newCSRFToken()
+			http.SetCookie(t.rw, &http.Cookie{
+				Name:     CSRFCookie,
+				Value:    t.token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   t.req.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	return t.token
+}
+
+// csrfDS adds the csrf token of the request to ds and to every data
+// source and row in it, so a form can be in a data-item at any depth.
+type csrfDS struct {
+	ante.DataSource
+	token *csrfToken
+}
+
+func withCSRF(ds ante.DataSource, rw http.ResponseWriter, req *http.Request) ante.DataSource {
+	return (&csrfToken{rw: rw, req: req}).wrap(ds)
+}
+
+func (t *csrfToken) wrap(ds ante.DataSource) ante.DataSource {
+	if ds == nil {
+		return nil
+	}
+	return &csrfDS{ds, t}
+}
+
+func (c *csrfDS) Get(key string) string {
+	if key == CSRFField {
+		return c.token.get()
+	}
+	return c.DataSource.Get(key)
+}
+func (c *csrfDS) GetDS(key string) ante.DataSource {
+	return c.token.wrap(c.DataSource.GetDS(key))
+}
+func (c *csrfDS) GetDSContext(ctx context.Context, key string) ante.DataSource {
+	return c.token.wrap(ante.GetDS(ctx, c.DataSource, key))
+}
+func (c *csrfDS) GetNext() ante.DataSource {
+	return c.token.wrap(c.DataSource.GetNext())
+}
+func (c *csrfDS) Empty() bool {
+	e, ok := c.DataSource.(ante.Emptier)
+	return ok && e.Empty()
+}
+
+// validCSRF is whether the form, or the header, has the token of the
+// session, or of the cookie when there is no session, like on a login form.
+func validCSRF(req *http.Request) bool {
+	expected := ""
+	if s := sessionOf(req); s != nil {
+		expected = s.csrf
+	} else if cookie, err := req.Cookie(CSRFCookie); err == nil {
+		expected = cookie.Value
+	}
+	if expected == "" {
+		return false
+	}
+	token := req.PostForm.Get(CSRFField)
+	if token == "" {
+		token = req.Header.Get(CSRFHeader)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
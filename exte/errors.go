@@ -0,0 +1,86 @@
+package exte
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"alesgaroth.com/anterior/ante"
+)
+
+// ErrorDB is a DB whose queries say why they failed, instead of
+// leaving no rows.
+type ErrorDB interface {
+	QueryErr(ctx context.Context, query string, args ...any) (ante.DataSource, error)
+}
+
+// ErrorAdapter makes db an ErrorDB. When db isn't one already, a query
+// fails when the data source it returns has an Err method that returns
+// an error, as one from sqlrior does.
+func ErrorAdapter(db DB) ErrorDB {
+	if edb, ok := db.(ErrorDB); ok {
+		return edb
+	}
+	return errorAdapter{db}
+}
+
+type errorAdapter struct {
+	db DB
+}
+
+func (ea errorAdapter) QueryErr(ctx context.Context, query string, args ...any) (ante.DataSource, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	_, hasArgs := ea.db.(ArgsDB)
+	if _, ok := ea.db.(ContextDB); !ok && !hasArgs && len(args) > 0 {
+		return nil, fmt.Errorf("unable to bind %d arguments, the DB does not implement ArgsDB", len(args))
+	}
+	ds := ContextAdapter(ea.db).QueryContext(ctx, query, args...)
+	if err := dsErr(ds); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// dsErr is the error of a data source that has an Err method.
+func dsErr(ds ante.DataSource) error {
+	if e, ok := ds.(interface{ Err() error }); ok {
+		return e.Err()
+	}
+	return nil
+}
+
+// failures are the errors of the queries of a request, which can run
+// at the same time.
+type failures struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (f *failures) add(err error) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs = append(f.errs, err)
+}
+
+func (f *failures) err() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return errors.Join(f.errs...)
+}
+
+// queryErr is why the queries of ds failed, if they did.
+func queryErr(ds ante.DataSource) error {
+	if f, ok := ds.(queryFailer); ok {
+		return f.queryErr()
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package exte
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"alesgaroth.com/anterior/ante"
 	uritemplate "github.com/yosida95/uritemplate/v3"
@@ -16,10 +23,47 @@ type Queryr interface {
 	DoQuery() ante.DataSource
 }
 
+// RequestQueryr is a Queryr whose queries depend on the request,
+// CreateHandler prefers it to DoQuery.
+type RequestQueryr interface {
+	Queryr
+	DoRequestQuery(req *http.Request) ante.DataSource
+}
+
 type Extedata struct {
 	Path     string  `yaml:"path"`
 	Template string  `yaml:"template"`
 	Queries  []Query `yaml:"queries"`
+	// ErrorTemplate is rendered, with a 500, when Template fails to fill in.
+	ErrorTemplate string `yaml:"error_template"`
+	// Status makes the entry the page for that status, like 404, instead
+	// of a route. It has no path.
+	Status int `yaml:"status"`
+	// Method is GET when empty. Routes for other methods have no template,
+	// they run their queries with the posted form and redirect.
+	Method string `yaml:"method"`
+	// Redirect is the uri template to redirect to after the queries of a
+	// form, the path of the request when empty.
+	Redirect string `yaml:"redirect"`
+	// CSRF is whether a form must post back the csrf token, it is
+	// when not set, unless the route is a GET.
+	CSRF *bool `yaml:"csrf"`
+	// Auth required means only logged in users get the route, Roles
+	// means only users with one of them do.
+	Auth  string   `yaml:"auth"`
+	Roles []string `yaml:"roles"`
+	// Login makes a form route log in the user with the posted username
+	// and password, Logout makes it log out.
+	Login  *Login `yaml:"login"`
+	Logout bool   `yaml:"logout"`
+	// Params are the query string parameters the queries can bind.
+	Params []QueryParam `yaml:"params"`
+	// Workers is how many of the queries run at once, DefaultWorkers
+	// when not set.
+	Workers int `yaml:"workers"`
+	// Timeout, like 5s, is how long the queries and the template of a
+	// request can take, DefaultTimeout when not set.
+	Timeout time.Duration `yaml:"timeout"`
 }
 type Query struct {
 	Name    string   `yaml:"name"`
@@ -27,39 +71,95 @@ type Query struct {
 	Columns []string `yaml:"columns"`
 	Single  bool     `yaml:"single"`
 	Joins   []Joined `yaml:"joins"`
+	// Required makes the page a 404 when the query has no rows.
+	Required bool `yaml:"required"`
+	// Paginate limits the query to the rows of the page the request asks for.
+	Paginate *Paginate `yaml:"paginate"`
+	// Lazy runs the query the first time the template asks for it, instead
+	// of with the other queries before the template is filled in, so a
+	// query the page doesn't show doesn't run. It is not the default: the
+	// queries that aren't lazy run at the same time, see Workers, while a
+	// lazy one runs on its own in the middle of filling in the template.
+	// Make the queries of a conditional section, or of a list shared by
+	// routes that don't all show them, lazy.
+	Lazy bool `yaml:"lazy"`
 }
 
+// Joined is a part of each row of a query. Without SQL its columns come
+// from the rows of the query itself, with SQL it is a child query run for
+// each row, binding the columns of the row as :name parameters.
 type Joined struct {
 	Name    string   `yaml:"name"`
 	Columns []string `yaml:"columns"`
+	SQL     string   `yaml:"sql"`
+	Joins   []Joined `yaml:"joins"`
 }
 
 type ExteQueryr struct {
 	Db      DB
 	Queries []Query
+	// Vars are the uri template variables of the path, in order.
+	Vars []string
+	// Params are the query string parameters of the route.
+	Params []QueryParam
+	// Workers is how many queries run at once, one at a time when it
+	// is less than two.
+	Workers int
+	// Timeout is how long CreateHandler gives a request for its queries
+	// and filling in its template, no limit when it is 0.
+	Timeout time.Duration
+
+	failures *failures
 }
 
+// DB runs the queries of the routes. It must be safe for concurrent use,
+// the queries of a request run at the same time, see Workers.
 type DB interface {
 	Query(string) ante.DataSource
 }
 
+// ArgsDB is a DB that can bind arguments to the $n placeholders of a query.
+type ArgsDB interface {
+	DB
+	QueryArgs(query string, args ...any) ante.DataSource
+}
+
 type qd struct {
+	// ds is nil until a lazy query runs.
 	ds       ante.DataSource
 	q        Query
 	ds_cache ante.DataSource
 }
 
 type exteDataSource struct {
-	datasources map[string]qd
+	datasources map[string]*qd
+	runner      *ExteQueryr
+	params      []param
+	// err is why the queries didn't all run.
+	err error
 }
 
 func (eds *exteDataSource) Get(key string) string {
 	return ""
 }
 func (eds *exteDataSource) GetDS(key string) ante.DataSource {
-	q := eds.datasources[key]
+	return eds.GetDSContext(context.Background(), key)
+}
+
+// GetDSContext runs a lazy query with ctx.
+func (eds *exteDataSource) GetDSContext(ctx context.Context, key string) ante.DataSource {
+	q, ok := eds.datasources[key]
+	if !ok {
+		return emptyDS(false)
+	}
 	if q.ds_cache == nil {
-		q.ds_cache = &riorAdapter{q.q, q.q.Columns, q.ds, make(map[string]ante.DataSource)}
+		if q.ds == nil {
+			q.ds = eds.runner.run(ctx, q.q.Name, q.q.pagedSQL(eds.params), eds.params)
+		}
+		if q.ds == nil {
+			q.ds = emptyDS(false)
+		}
+		q.ds_cache = newRiorAdapter(q.q, q.ds, eds.runner, eds.params)
 	}
 	return q.ds_cache
 }
@@ -68,26 +168,179 @@ func (q *exteDataSource) GetNext() ante.DataSource {
 	return nil
 }
 
+// missingRequired is the name of the first required query without rows.
+func (eds *exteDataSource) missingRequired(ctx context.Context) string {
+	for _, query := range eds.runner.Queries {
+		if !query.Required {
+			continue
+		}
+		if ra, ok := eds.GetDSContext(ctx, query.Name).(*riorAdapter); ok && ra.Empty() {
+			return query.Name
+		}
+	}
+	return ""
+}
+
+type requirer interface {
+	missingRequired(ctx context.Context) string
+}
+
+// queryErr is why the queries failed, their rows included, once they
+// have been read.
+func (eds *exteDataSource) queryErr() error {
+	errs := []error{eds.err, eds.runner.failures.err()}
+	for _, query := range eds.runner.Queries {
+		if q := eds.datasources[query.Name]; q.ds != nil {
+			if err := dsErr(q.ds); err != nil {
+				errs = append(errs, fmt.Errorf("query %s: %w", query.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// queryFailer is a DataSource whose queries can fail, which makes the
+// page a 500.
+type queryFailer interface {
+	queryErr() error
+}
+
+// failedIn are the queries whose data-item the fill in error err is
+// about.
+func (eds *exteDataSource) failedIn(err error) []string {
+	items := failedItems(err)
+	var names []string
+	for _, query := range eds.runner.Queries {
+		if slices.Contains(items, query.Name) {
+			names = append(names, query.Name)
+		}
+	}
+	return names
+}
+
+// failedItems are the items of the outermost ante.ItemErrors in err, the
+// items inside them aren't queries.
+func failedItems(err error) []string {
+	if itemErr, ok := err.(*ante.ItemError); ok {
+		return []string{itemErr.Item}
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		var itemErr *ante.ItemError
+		if errors.As(err, &itemErr) {
+			return []string{itemErr.Item}
+		}
+		return nil
+	}
+	var items []string
+	for _, err := range joined.Unwrap() {
+		items = append(items, failedItems(err)...)
+	}
+	return items
+}
+
+// failedQueries names the queries of ds that err is about, for the log.
+func failedQueries(ds ante.DataSource, err error) string {
+	eds, ok := ds.(*exteDataSource)
+	if !ok {
+		return ""
+	}
+	names := eds.failedIn(err)
+	if len(names) == 0 {
+		return ""
+	}
+	return " query " + strings.Join(names, ", ")
+}
+
+// riorAdapter is the result of a query. A single query is one row read
+// with Get, the rows of other queries are read with GetNext.
 type riorAdapter struct {
-	q        Query
-	cols     []string
-	ds       ante.DataSource
-	ds_cache map[string]ante.DataSource
+	q      Query
+	reader *rowReader
+	runner *ExteQueryr
+	params []param
+	rows   []*rowAdapter
+	pos    int
+	first  *rowAdapter
 }
 
-func (q *riorAdapter) Get(key string) string {
-	for _, col := range q.cols {
-		if col == key {
-			return q.ds.Get(key)
+func newRiorAdapter(q Query, ds ante.DataSource, runner *ExteQueryr, params []param) *riorAdapter {
+	ra := &riorAdapter{q: q, runner: runner, params: params}
+	ra.reader = &rowReader{spec: ra.spec(), ds: ds}
+	return ra
+}
+
+func (q *riorAdapter) spec() Joined {
+	return Joined{q.q.Name, q.q.Columns, q.q.SQL, q.q.Joins}
+}
+
+// row returns row i of the result, reading up to it, or nil past the end.
+func (q *riorAdapter) row(i int) *rowAdapter {
+	for len(q.rows) <= i {
+		group := q.reader.readGroup()
+		if group == nil {
+			return nil
 		}
+		q.rows = append(q.rows, newRowAdapter(q.spec(), group, q.runner, q.params))
 	}
-	return ""
+	return q.rows[i]
 }
-func (q riorAdapter) GetDS(key string) ante.DataSource {
-	return emptyDS(false)
+
+// selfRow is whether the result is its own first row, which it is when it
+// has no rows to iterate over but doesn't say it is empty either, like
+// the one row of a DB whose data sources don't iterate.
+func (q *riorAdapter) selfRow() bool {
+	if q.reader.ds == nil || q.row(0) != nil {
+		return false
+	}
+	e, ok := q.reader.ds.(ante.Emptier)
+	return !ok || !e.Empty()
 }
-func (q riorAdapter) GetNext() ante.DataSource {
-	return nil
+
+// firstRow is the first row of the result, see selfRow.
+func (q *riorAdapter) firstRow() *rowAdapter {
+	if q.first == nil {
+		q.first = q.row(0)
+		if q.first == nil {
+			var rows []ante.DataSource
+			if q.selfRow() {
+				rows = []ante.DataSource{q.reader.ds}
+			}
+			q.first = newRowAdapter(q.spec(), rows, q.runner, q.params)
+		}
+	}
+	return q.first
+}
+
+func (q *riorAdapter) Get(key string) string {
+	return q.firstRow().Get(key)
+}
+func (q *riorAdapter) GetDS(key string) ante.DataSource {
+	return q.firstRow().GetDS(key)
+}
+func (q *riorAdapter) GetDSContext(ctx context.Context, key string) ante.DataSource {
+	return q.firstRow().GetDSContext(ctx, key)
+}
+
+// Empty is whether the query has no rows, for data-if and required
+// queries. A result that is its own first row isn't empty.
+func (q *riorAdapter) Empty() bool {
+	return q.row(0) == nil && !q.selfRow()
+}
+
+// GetNext returns each row in turn, then nil, and starts over after that
+// so a template can loop over the same query twice.
+func (q *riorAdapter) GetNext() ante.DataSource {
+	if q.q.Single {
+		return nil
+	}
+	row := q.row(q.pos)
+	if row == nil {
+		q.pos = 0
+		return nil
+	}
+	q.pos += 1
+	return row
 }
 
 type emptyDS bool
@@ -101,21 +354,93 @@ func (emptyDS) Get(key string) string {
 func (q emptyDS) GetNext() ante.DataSource {
 	return nil
 }
+func (emptyDS) Empty() bool {
+	return true
+}
 
 func (cq *ExteQueryr) DoQuery() ante.DataSource {
-	eds := &exteDataSource{make(map[string]qd)}
+	return cq.doQuery(context.Background(), nil)
+}
+
+// DoRequestQuery binds the uri template variables matched from req, the
+// logged in user and the query string parameters to the :name
+// placeholders of the queries.
+func (cq *ExteQueryr) DoRequestQuery(req *http.Request) ante.DataSource {
+	return cq.doQuery(req.Context(), cq.requestParams(req))
+}
+
+// requestParams are the uri template variables, then the columns of the
+// logged in user as :user_<column>, then the query string parameters.
+func (cq *ExteQueryr) requestParams(req *http.Request) []param {
+	var params []param
+	for _, name := range cq.Vars {
+		params = append(params, param{name, req.PathValue(name), true})
+	}
+	params = append(params, userParams(req)...)
+	query, _ := cq.queryParams(req)
+	return append(params, query...)
+}
+
+// doQuery runs the queries, see runAll, a query that fails leaves no rows.
+// Lazy queries are left to run when the template first asks for them.
+func (cq *ExteQueryr) doQuery(ctx context.Context, params []param) ante.DataSource {
+	if cq.Db == nil {
+		panic("cq.Db is nil")
+	}
+	// a copy, for the failures of this request
+	request := *cq
+	cq = &request
+	cq.failures = &failures{}
+	var eager []Query
 	for _, query := range cq.Queries {
-		if cq.Db == nil {
-			panic("cq.Db is nil")
+		if !query.Lazy {
+			eager = append(eager, query)
+		}
+	}
+	results, err := cq.runAll(ctx, eager, params)
+	eds := &exteDataSource{make(map[string]*qd), cq, params, err}
+	for _, query := range cq.Queries {
+		eds.datasources[query.Name] = &qd{nil, query, nil}
+	}
+	for i, query := range eager {
+		ds := results[i]
+		if ds == nil {
+			ds = emptyDS(false)
 		}
-		eds.datasources[query.Name] = qd{cq.Db.Query(query.SQL), query, nil}
+		eds.datasources[query.Name].ds = ds
 	}
 	return eds
 }
 
+// query runs the query called name with params bound to it.
+func (cq *ExteQueryr) query(ctx context.Context, name string, query string, params []param) (ante.DataSource, error) {
+	sql, args := bindParams(query, params)
+	ds, err := ErrorAdapter(cq.Db).QueryErr(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", name, err)
+	}
+	return ds, nil
+}
+
+// run is query for the data sources of a request, a query that fails is
+// logged and has no rows, and its error is one of the failures of the
+// request.
+func (cq *ExteQueryr) run(ctx context.Context, name string, query string, params []param) ante.DataSource {
+	ds, err := cq.query(ctx, name, query, params)
+	if err != nil {
+		log.Print(err)
+		cq.failures.add(err)
+		return emptyDS(false)
+	}
+	return ds
+}
+
 type HandlerEntry struct {
 	re      *regexp.Regexp
+	tmpl    *uritemplate.Template
 	handler http.HandlerFunc
+	// method is the only method the entry handles, any method when empty.
+	method string
 }
 
 type handlerCollector struct {
@@ -124,6 +449,8 @@ type handlerCollector struct {
 	tmplengine TemplateEngine
 	handlers   *[]HandlerEntry
 	plugins    []Plugin
+	pages      statusPages
+	sessions   *sessionStore
 }
 
 type Plugin interface {
@@ -133,22 +460,28 @@ type TemplateEngine interface {
 	ParseTemplate(f io.Reader) (ante.AnteTemplate, error)
 }
 
-func (e *handlerCollector) collectHandlers(ed Extedata) {
-	f, err := os.Open(ed.Template)
+func (e *handlerCollector) parseTemplate(filename string) (ante.AnteTemplate, error) {
+	f, err := os.Open(filename)
 	if err != nil {
-		e.errs = append(e.errs, err)
-		return
+		return nil, err
 	}
 	defer f.Close()
-	tmplt, err := e.tmplengine.ParseTemplate(f)
-	if err != nil {
-		e.errs = append(e.errs, err)
-		return
+	return e.tmplengine.ParseTemplate(f)
+}
+
+func (e *handlerCollector) collectHandlers(ed Extedata) {
+	pages := e.pages
+	if ed.ErrorTemplate != "" {
+		errorPage, err := e.parseTemplate(ed.ErrorTemplate)
+		if err != nil {
+			e.errs = append(e.errs, err)
+			return
+		}
+		pages = pages.with(http.StatusInternalServerError, &statusPage{errorPage, nil})
 	}
 	if e.db == nil {
 		panic("e.db is nil")
 	}
-	handler := CreateHandler(tmplt, &ExteQueryr{e.db, ed.Queries})
 	defer func() {
 		if r := recover(); r != nil {
 			e.errs = append(e.errs, fmt.Errorf("\nrecovering from panic in mux.Handle()\n%v", r))
@@ -157,12 +490,60 @@ func (e *handlerCollector) collectHandlers(ed Extedata) {
 	tmpl, err := uritemplate.New(ed.Path)
 	if err != nil {
 		e.errs = append(e.errs, err)
+		return
+	}
+	if err := ed.checkPaginate(); err != nil {
+		e.errs = append(e.errs, fmt.Errorf("route %s: %v", ed.Path, err))
+		return
+	}
+	params := ed.params()
+	for _, p := range params {
+		if err := p.check(); err != nil {
+			e.errs = append(e.errs, fmt.Errorf("route %s: %v", ed.Path, err))
+			return
+		}
+	}
+	queryr := &ExteQueryr{Db: e.db, Queries: ed.Queries, Vars: tmpl.Varnames(), Params: params, Workers: ed.workers(), Timeout: ed.timeout()}
+	var handler http.HandlerFunc
+	if ed.method() == http.MethodGet {
+		tmplt, err := e.parseTemplate(ed.Template)
+		if err != nil {
+			e.errs = append(e.errs, err)
+			return
+		}
+		handler = createHandler(tmplt, queryr, pages)
+	} else {
+		redirect, err := ed.redirect()
+		if err != nil {
+			e.errs = append(e.errs, err)
+			return
+		}
+		switch {
+		case ed.Login != nil:
+			handler = createLoginHandler(queryr, ed.Login, e.sessions, redirect, ed.checksCSRF(), pages)
+		case ed.Logout:
+			handler = createLogoutHandler(queryr, e.sessions, redirect, ed.checksCSRF(), pages)
+		default:
+			handler = createFormHandler(queryr, redirect, ed.checksCSRF(), pages)
+		}
+	}
+	if ed.needsAuth() {
+		handler = requireAuth(handler, ed.Roles, pages)
 	}
 	re := tmpl.Regexp()
-	*e.handlers = append(*e.handlers, HandlerEntry{re, handler})
+	*e.handlers = append(*e.handlers, HandlerEntry{re, tmpl, handler, ed.method()})
 	ed.plugins(e)
 }
 
+func (e *handlerCollector) collectStatusPage(ed Extedata) {
+	tmplt, err := e.parseTemplate(ed.Template)
+	if err != nil {
+		e.errs = append(e.errs, err)
+		return
+	}
+	e.pages[ed.Status] = &statusPage{tmplt, &ExteQueryr{Db: e.db, Queries: ed.Queries, Workers: ed.workers(), Timeout: ed.timeout()}}
+}
+
 func (ed Extedata) plugins(e *handlerCollector) {
 	for _, plugin := range e.plugins {
 		*e.handlers = append(*e.handlers, plugin.GetHandlers(ed, e.tmplengine, e.db)...)
@@ -175,23 +556,41 @@ func CreateHandlers(filename string, db DB, tmplengine TemplateEngine, plugins [
 		return nil, err
 	}
 	entries := []HandlerEntry{}
-	handlerrs := &handlerCollector{[]error{}, db, tmplengine, &entries, plugins}
+	handlerrs := &handlerCollector{[]error{}, db, tmplengine, &entries, plugins, make(statusPages), newSessionStore()}
+	for _, ed := range extedata {
+		if ed.Status != 0 {
+			handlerrs.collectStatusPage(ed)
+		}
+	}
 	for _, ed := range extedata {
-		handlerrs.collectHandlers(ed)
+		if ed.Status == 0 {
+			handlerrs.collectHandlers(ed)
+		}
 	}
 	notFoundHandler := func(rw http.ResponseWriter, req *http.Request) {
-		rw.Write([]byte("yo, I think we hit a snag and an error 404"))
+		handlerrs.pages.write(rw, req, http.StatusNotFound)
+	}
+	routes := newRouter()
+	for _, entry := range *handlerrs.handlers {
+		if err := routes.add(entry); err != nil {
+			handlerrs.errs = append(handlerrs.errs, err)
+		}
 	}
 	handler := func(rw http.ResponseWriter, req *http.Request) {
-		for _, entry := range *handlerrs.handlers {
-			if entry.re.MatchString(req.URL.Path) { // we can do better!
-				req.Pattern = entry.re.String()
-				entry.handler(rw, req)
-				return
-			}
+		entry, allowed, ok := routes.find(req.Method, req.URL.Path)
+		if !ok && len(allowed) > 0 {
+			rw.Header().Set("Allow", strings.Join(allowed, ", "))
+			handlerrs.pages.write(rw, req, http.StatusMethodNotAllowed)
+			return
 		}
-		// 404!
-		notFoundHandler(rw, req)
+		if !ok {
+			notFoundHandler(rw, req)
+			return
+		}
+		req = handlerrs.sessions.withSession(req)
+		req.Pattern = entry.pattern()
+		entry.setPathValues(req)
+		entry.handler(rw, req)
 	}
 	if len(handlerrs.errs) > 0 {
 		return handler, fmt.Errorf("errors: %v", handlerrs.errs)
@@ -199,10 +598,88 @@ func CreateHandlers(filename string, db DB, tmplengine TemplateEngine, plugins [
 	return handler, nil
 }
 
+func (entry HandlerEntry) pattern() string {
+	if entry.tmpl == nil {
+		return entry.re.String()
+	}
+	return entry.tmpl.Raw()
+}
+
+func (entry HandlerEntry) setPathValues(req *http.Request) {
+	if entry.tmpl == nil {
+		return
+	}
+	for name, value := range entry.tmpl.Match(req.URL.Path) {
+		req.SetPathValue(name, value.String())
+	}
+}
+
 func CreateHandler(template ante.AnteTemplate, q Queryr) http.HandlerFunc {
+	return CreateHandlerWithErrorPage(template, q, nil)
+}
+
+// CreateHandlerWithErrorPage fills template into a buffer first, so that
+// when it fails the response is a 500 rendered with errorPage instead of
+// half a page. The error is logged with the pattern of the route.
+func CreateHandlerWithErrorPage(template ante.AnteTemplate, q Queryr, errorPage ante.AnteTemplate) http.HandlerFunc {
+	var pages statusPages
+	if errorPage != nil {
+		pages = statusPages{http.StatusInternalServerError: &statusPage{errorPage, nil}}
+	}
+	return createHandler(template, q, pages)
+}
+
+func createHandler(template ante.AnteTemplate, q Queryr, pages statusPages) http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		template.FillIn(rw, q.DoQuery())
+		var vars []string
+		if cq, ok := q.(*ExteQueryr); ok {
+			if _, err := cq.queryParams(req); err != nil {
+				log.Printf("route %s: %v", req.Pattern, err)
+				pages.write(rw, req, http.StatusBadRequest)
+				return
+			}
+			vars = cq.Vars
+			if cq.Timeout > 0 {
+				ctx, cancel := context.WithTimeout(req.Context(), cq.Timeout)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+		}
+		ds := doQuery(q, req)
+		if err := queryErr(ds); err != nil {
+			log.Printf("route %s: %v", req.Pattern, err)
+			pages.write(rw, req, http.StatusInternalServerError)
+			return
+		}
+		if r, ok := ds.(requirer); ok {
+			if name := r.missingRequired(req.Context()); name != "" {
+				log.Printf("route %s: required query %s has no rows", req.Pattern, name)
+				pages.write(rw, req, http.StatusNotFound)
+				return
+			}
+		}
+		buf := &bytes.Buffer{}
+		err := ante.FillIn(req.Context(), template, buf, withCSRF(withReserved(ds, req, vars), rw, req))
+		if err != nil {
+			log.Printf("route %s%s: %v", req.Pattern, failedQueries(ds, err), err)
+			pages.write(rw, req, http.StatusInternalServerError)
+			return
+		}
+		// the lazy queries and joins run while filling in
+		if err := queryErr(ds); err != nil {
+			log.Printf("route %s: %v", req.Pattern, err)
+			pages.write(rw, req, http.StatusInternalServerError)
+			return
+		}
+		rw.Write(buf.Bytes())
+	}
+}
+
+func doQuery(q Queryr, req *http.Request) ante.DataSource {
+	if rq, ok := q.(RequestQueryr); ok {
+		return rq.DoRequestQuery(req)
 	}
+	return q.DoQuery()
 }
 
 func ParseYaml(filename string) ([]Extedata, error) {
@@ -2,12 +2,20 @@ package exte_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"alesgaroth.com/anterior/ante"
 	"alesgaroth.com/anterior/exte"
@@ -96,6 +104,58 @@ func TestDifferentHandlersGiveDifferent(t *testing.T) {
 	testIt(t, template2, request, SimpleRior(2), "GoodBye 2")
 }
 
+func TestFillInErrorIsA500(t *testing.T) {
+	request, err := createRootRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errorPage, err := SimpleAnte(1).ParseTemplate(strings.NewReader("Oops "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := exte.CreateHandlerWithErrorPage(FailingTemplate("half a page"), SimpleRior(1), errorPage)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, request)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 got %d", rec.Code)
+	}
+	if got := rec.Body.String(); got != "Oops " {
+		t.Errorf("expected the error page \"Oops \" got \"%v\"", got)
+	}
+
+	rec = httptest.NewRecorder()
+	exte.CreateHandler(FailingTemplate("half a page"), SimpleRior(1)).ServeHTTP(rec, request)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 got %d", rec.Code)
+	}
+	if got := rec.Body.String(); strings.Contains(got, "half a page") {
+		t.Errorf("expected no half written page got \"%v\"", got)
+	}
+
+	logged := &bytes.Buffer{}
+	log.SetOutput(logged)
+	defer log.SetOutput(os.Stderr)
+	eq := &exte.ExteQueryr{Db: &TableRior{}, Queries: []exte.Query{{Name: "post", SQL: "SELECT title FROM posts"}}}
+	req := httptest.NewRequest("GET", "/blog/p7.html", nil)
+	req.Pattern = "/blog/p{postid}.html"
+	exte.CreateHandler(ItemFailingTemplate("post"), eq).ServeHTTP(httptest.NewRecorder(), req)
+	if got := logged.String(); !strings.Contains(got, "route /blog/p{postid}.html query post: ") {
+		t.Errorf("expected the route and the query in the log got %v", got)
+	}
+
+	// the comments of the post fail, not the comments query
+	logged.Reset()
+	eq = &exte.ExteQueryr{Db: &TableRior{tables: map[string][]map[string]string{
+		"SELECT title FROM posts":   {{"title": "Hello"}},
+		"SELECT body FROM comments": {{"body": "Hi"}},
+	}}, Queries: []exte.Query{{Name: "post", SQL: "SELECT title FROM posts"}, {Name: "comments", SQL: "SELECT body FROM comments"}}}
+	template := ante.NewAnteTemplateWithSanitizer("<div data-item='post'><div data-item='comments'><p data-html='body'></p></div></div>", FailingSanitizer(1))
+	exte.CreateHandler(template, eq).ServeHTTP(httptest.NewRecorder(), req)
+	if got := logged.String(); !strings.Contains(got, "route /blog/p{postid}.html query post: ") {
+		t.Errorf("expected only the post query in the log got %v", got)
+	}
+}
+
 func TestRouting(t *testing.T) {
 	filename := "config.yaml"
 	handlers, err := exte.CreateHandlers(filename, SimpleRior(3), StaticAnte(1), nil)
@@ -142,6 +202,271 @@ func TestRouting(t *testing.T) {
 	}
 }
 
+func TestNotFoundPage(t *testing.T) {
+	handlers, err := exte.CreateHandlers("statuspages.yaml", SimpleRior(3), AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := createRequest("/nothing/here")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 got %d", rec.Code)
+	}
+	expected := "<html><body><h1 data-field='status'>404</h1><p data-field='message'>Not Found</p></body></html>\n"
+	if got := rec.Body.String(); got != expected {
+		t.Errorf("expected \"%v\" got \"%v\"", expected, got)
+	}
+
+	handlers, err = exte.CreateHandlers("config.yaml", SimpleRior(3), StaticAnte(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without a page got %d", rec.Code)
+	}
+}
+
+func TestStatusPageQueriesOutliveTheRequest(t *testing.T) {
+	db := &TableRior{tables: map[string][]map[string]string{
+		"SELECT title FROM posts": {{"title": "Hello"}},
+	}}
+	handlers, err := exte.CreateHandlers("statusqueries.yaml", db, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil).WithContext(ctx))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), ">Hello</span>") {
+		t.Errorf("expected the 404 page with its query got %d %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRequiredQueryWithoutRowsIsA404(t *testing.T) {
+	req, err := createRequest("/required/9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := &TableRior{tables: map[string][]map[string]string{}}
+	handlers, err := exte.CreateHandlers("statuspages.yaml", empty, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 got %d", rec.Code)
+	}
+
+	full := &TableRior{tables: map[string][]map[string]string{
+		"SELECT title FROM posts WHERE id = $1": {{"title": "Hello"}},
+	}}
+	handlers, err = exte.CreateHandlers("statuspages.yaml", full, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 got %d", rec.Code)
+	}
+}
+
+func TestMostSpecificRouteWins(t *testing.T) {
+	handlers, err := exte.CreateHandlers("routing.yaml", SimpleRior(3), StaticAnte(1), nil)
+	if err == nil || !strings.Contains(err.Error(), "/blog/{other}.html conflicts with route /blog/{id}.html") {
+		t.Errorf("expected /blog/{other}.html to conflict got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "/files{/rest*} conflicts with route /files{/path*}") {
+		t.Errorf("expected /files{/rest*} to conflict got %v", err)
+	}
+	type testdata struct {
+		path     string
+		template string
+	}
+	tests := []testdata{
+		testdata{"/blog/", "blog.html"},
+		testdata{"/blog/p7.html", "post.html"},
+		testdata{"/blog/x.html", "root.html"},
+		testdata{"/about", "notfound.html"},
+		testdata{"/files/a/b", "root.html"},
+		testdata{"/docs/index", "root.html"},
+	}
+	for _, td := range tests {
+		req, err := createRequest(td.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := os.ReadFile(td.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tester(t, handlers, req, string(expected[:min(len(expected), 100)]))
+	}
+	req, err := createRequest("/blog/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected /blog/a/b to be a 404 got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handlers.ServeHTTP(rec, httptest.NewRequest("DELETE", "/docs/index", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, POST" {
+		t.Errorf("expected a 405 allowing GET, POST got %d %v", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestFormPostRedirects(t *testing.T) {
+	db := &ExecRior{}
+	handlers, err := exte.CreateHandlers("form.yaml", db, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"text": {"Nice post"}, "postid": {"8"}, exte.CSRFField: {"token"}}
+	req := httptest.NewRequest("POST", "/comments/7", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: exte.CSRFCookie, Value: "token"})
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("expected status 303 got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Location"); got != "/comments/7#new" {
+		t.Errorf("expected to be redirected to /comments/7#new got %v", got)
+	}
+	if len(db.execs) != 1 || db.execs[0] != "INSERT INTO comments (postid, text) VALUES ($1, $2)" {
+		t.Errorf("expected the insert to be run got %v", db.execs)
+	}
+	if len(db.args) != 1 || len(db.args[0]) != 2 || db.args[0][0] != "7" || db.args[0][1] != "Nice post" {
+		t.Errorf("expected args [7 Nice post] got %v", db.args)
+	}
+
+	req = httptest.NewRequest("DELETE", "/comments/7", nil)
+	rec = httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, POST" {
+		t.Errorf("expected GET, POST to be allowed got %v", got)
+	}
+}
+
+// TxRior runs the execs in transactions, failing the queries in fail.
+type TxRior struct {
+	ExecRior
+	fail       map[string]error
+	rolledBack int
+}
+
+func (tr *TxRior) BeginTx(ctx context.Context) (exte.Tx, error) {
+	return &TestTx{db: tr}, nil
+}
+
+type TestTx struct {
+	db    *TxRior
+	execs []string
+}
+
+func (tx *TestTx) ExecContext(ctx context.Context, sql string, args ...any) error {
+	if err, ok := tx.db.fail[sql]; ok {
+		return err
+	}
+	tx.execs = append(tx.execs, sql)
+	return nil
+}
+func (tx *TestTx) Commit() error {
+	tx.db.execs = append(tx.db.execs, tx.execs...)
+	return nil
+}
+func (tx *TestTx) Rollback() error {
+	tx.db.rolledBack++
+	return nil
+}
+
+func TestFormQueriesRunInATransaction(t *testing.T) {
+	db := &TxRior{fail: map[string]error{"UPDATE posts SET comments = comments + 1 WHERE id = $1": fmt.Errorf("deadlock")}}
+	handlers, err := exte.CreateHandlers("form.yaml", db, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func() int {
+		form := url.Values{exte.CSRFField: {"token"}}
+		req := httptest.NewRequest("POST", "/comments/7/approve", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: exte.CSRFCookie, Value: "token"})
+		rec := httptest.NewRecorder()
+		handlers.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if got := post(); got != http.StatusInternalServerError {
+		t.Errorf("expected status 500 got %d", got)
+	}
+	if len(db.execs) != 0 || db.rolledBack != 1 {
+		t.Errorf("expected the approve to be rolled back got %v and %d rollbacks", db.execs, db.rolledBack)
+	}
+	db.fail = nil
+	if got := post(); got != http.StatusSeeOther {
+		t.Errorf("expected status 303 got %d", got)
+	}
+	if len(db.execs) != 2 {
+		t.Errorf("expected both queries to be committed got %v", db.execs)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	db := &ExecRior{TableRior: TableRior{tables: map[string][]map[string]string{
+		"SELECT title FROM posts WHERE id = $1": {{"title": "Hello"}},
+	}}}
+	handlers, err := exte.CreateHandlers("csrf.yaml", db, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, httptest.NewRequest("GET", "/csrf/7", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != exte.CSRFCookie || cookies[0].Value == "" {
+		t.Fatalf("expected a %v cookie got %v", exte.CSRFCookie, cookies)
+	}
+	token := cookies[0].Value
+	if got := rec.Body.String(); strings.Count(got, "value='"+token+"'") != 2 || !strings.Contains(got, ">Hello</b>") {
+		t.Errorf("expected the token in both forms, the one in the post too, got %v", got)
+	}
+
+	post := func(formToken string) int {
+		form := url.Values{"text": {"Nice post"}, exte.CSRFField: {formToken}}
+		req := httptest.NewRequest("POST", "/csrf/7", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[0])
+		rec := httptest.NewRecorder()
+		handlers.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if got := post("wrong"); got != http.StatusForbidden {
+		t.Errorf("expected a wrong token to be a 403 got %d", got)
+	}
+	if got := post(""); got != http.StatusForbidden {
+		t.Errorf("expected a missing token to be a 403 got %d", got)
+	}
+	if len(db.execs) != 0 {
+		t.Errorf("expected no queries to run without the token got %v", db.execs)
+	}
+	if got := post(token); got != http.StatusSeeOther {
+		t.Errorf("expected the token to be accepted got %d", got)
+	}
+}
+
 func TestSingles(t *testing.T) {
 
 	// test that queries returning a single row return the expected
@@ -200,6 +525,54 @@ func TestMultipleRows(t *testing.T) {
 	}
 }
 
+func TestRowsAreIterated(t *testing.T) {
+	// test that a query that is not single loops over its rows in a template
+	queries := []exte.Query{
+		exte.Query{
+			Name:    "posts",
+			SQL:     "posts",
+			Columns: []string{"title"},
+		},
+		exte.Query{
+			Name:    "post",
+			SQL:     "posts",
+			Columns: []string{"title"},
+			Single:  true,
+		},
+	}
+	back := &TableRior{tables: map[string][]map[string]string{
+		"posts": {
+			{"title": "A"},
+			{"title": "B"},
+		},
+	}}
+	eq := &exte.ExteQueryr{
+		Db:      back,
+		Queries: queries,
+	}
+	template := ante.NewAnteTemplate("<ul data-item='posts'><li data-repeating='1'><b data-field='title'></b></li></ul><p data-item='post'><b data-field='title'></b></p>")
+	request, err := createRootRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testIt(t, template, request, eq, "<ul data-item='posts'><li data-repeating='1'><b data-field='title'>A</b></li><li data-repeating='1'><b data-field='title'>B</b></li></ul><p data-item='post'><b data-field='title'>A</b></p>")
+
+	ds := eq.DoQuery()
+	posts := ds.GetDS("posts")
+	if got := posts.Get("title"); got != "A" {
+		t.Errorf("expected the first title 'A' got '%v'", got)
+	}
+	if got := rowValues(posts, "title"); got != "A,B" {
+		t.Errorf("expected titles 'A,B' got '%v'", got)
+	}
+	if got := rowValues(posts, "title"); got != "A,B" {
+		t.Errorf("expected to loop over titles 'A,B' again got '%v'", got)
+	}
+	if got := rowValues(ds.GetDS("post"), "title"); got != "" {
+		t.Errorf("expected a single query to have no rows to loop over got '%v'", got)
+	}
+}
+
 func TestColumns(t *testing.T) {
 	// test that you can only see the columns for the table, not its joins
 	queries := []exte.Query{
@@ -256,6 +629,184 @@ func TestJoins(t *testing.T) {
 func TestJoints(t *testing.T) {
 	// test that you can navigate into the joins that have their own subqueries ...
 	// and it will show the correct data
+	queries := []exte.Query{
+		exte.Query{
+			Name:    "post",
+			SQL:     "SELECT id, title FROM posts",
+			Columns: []string{"id", "title"},
+			Single:  true,
+			Joins: []exte.Joined{
+				exte.Joined{
+					Name:    "comments",
+					SQL:     "SELECT text FROM comments WHERE postid = :id",
+					Columns: []string{"text"},
+				},
+			},
+		},
+	}
+	back := &TableRior{tables: map[string][]map[string]string{
+		"SELECT id, title FROM posts": {
+			{"id": "7", "title": "Hello"},
+		},
+		"SELECT text FROM comments WHERE postid = $1": {
+			{"text": "first"},
+			{"text": "second"},
+		},
+	}}
+	eq := exte.ExteQueryr{
+		Db:      back,
+		Queries: queries,
+	}
+	ds := eq.DoQuery()
+	comments := ds.GetDS("post").GetDS("comments")
+	if got := rowValues(comments, "text"); got != "first,second" {
+		t.Errorf("expected comments 'first,second' got '%v'", got)
+	}
+	if len(back.args) != 2 || len(back.args[1]) != 1 || back.args[1][0] != "7" {
+		t.Errorf("expected the comments query to be bound to [7] got %v", back.args)
+	}
+}
+
+func TestJoinColumnsWinOverPathVariables(t *testing.T) {
+	// test that a join binds the id of each row, not the id of the path
+	queries := []exte.Query{
+		exte.Query{
+			Name:    "posts",
+			SQL:     "SELECT id FROM posts",
+			Columns: []string{"id"},
+			Joins: []exte.Joined{
+				exte.Joined{
+					Name:    "comments",
+					SQL:     "SELECT text FROM comments WHERE postid = :id",
+					Columns: []string{"text"},
+				},
+			},
+		},
+	}
+	back := &TableRior{tables: map[string][]map[string]string{
+		"SELECT id FROM posts": {{"id": "1"}, {"id": "2"}},
+	}}
+	eq := exte.ExteQueryr{
+		Db:      back,
+		Queries: queries,
+		Vars:    []string{"id"},
+	}
+	req, err := createRequest("/blog/99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetPathValue("id", "99")
+	posts := eq.DoRequestQuery(req).GetDS("posts")
+	for row := posts.GetNext(); row != nil; row = posts.GetNext() {
+		row.GetDS("comments")
+	}
+	if got := fmt.Sprint(back.args[1:]); got != "[[1] [2]]" {
+		t.Errorf("expected the comments queries to be bound to [1] and [2] got %v", got)
+	}
+}
+
+func TestSplitJoins(t *testing.T) {
+	// test that the rows of a join without sql are split off the rows of its query
+	queries := []exte.Query{
+		exte.Query{
+			Name:    "posts",
+			SQL:     "posts",
+			Columns: []string{"id", "title"},
+			Joins: []exte.Joined{
+				exte.Joined{Name: "authors", Columns: []string{"name"}},
+				exte.Joined{Name: "tags", Columns: []string{"tag"}},
+			},
+		},
+	}
+	back := &TableRior{tables: map[string][]map[string]string{
+		"posts": {
+			{"id": "1", "title": "A", "name": "alice", "tag": "go"},
+			{"id": "1", "title": "A", "name": "alice", "tag": "sql"},
+			{"id": "1", "title": "A", "name": "bob", "tag": "go"},
+			{"id": "1", "title": "A", "name": "bob", "tag": "sql"},
+			{"id": "2", "title": "B", "name": "carol", "tag": "go"},
+		},
+	}}
+	eq := exte.ExteQueryr{
+		Db:      back,
+		Queries: queries,
+	}
+	posts := eq.DoQuery().GetDS("posts")
+	if got := posts.Get("title"); got != "A" {
+		t.Errorf("expected title 'A' got '%v'", got)
+	}
+	if got := rowValues(posts.GetDS("authors"), "name"); got != "alice,bob" {
+		t.Errorf("expected authors 'alice,bob' got '%v'", got)
+	}
+	if got := rowValues(posts.GetDS("tags"), "tag"); got != "go,sql" {
+		t.Errorf("expected tags 'go,sql' got '%v'", got)
+	}
+}
+
+func rowValues(ds ante.DataSource, key string) string {
+	var values []string
+	for row := ds.GetNext(); row != nil; row = ds.GetNext() {
+		values = append(values, row.Get(key))
+	}
+	return strings.Join(values, ",")
+}
+
+func TestPathVariablesAreBound(t *testing.T) {
+	db := &ArgsRior{}
+	handlers, err := exte.CreateHandlers("config.yaml", db, StaticAnte(1), nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req, err := createRequest("/blog/p7.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tester(t, handlers, req, `<html>
+<head>
+<title>My blog post</title>
+</head>
+<body>
+	<div class="aw_menusections">
+		<div class`)
+	if len(db.queries) != 3 {
+		t.Fatalf("expected 3 queries got %d", len(db.queries))
+	}
+	// the queries run at the same time, in any order
+	post := slices.IndexFunc(db.queries, func(q string) bool { return strings.Contains(q, "posts.id") })
+	if post < 0 || !strings.Contains(db.queries[post], "WHERE posts.id = $1") || strings.Contains(db.queries[post], ":postid") {
+		t.Fatalf("expected :postid to be replaced by $1 in %v", db.queries)
+	}
+	if len(db.args[post]) != 1 || db.args[post][0] != "7" {
+		t.Errorf("expected args [7] got %v", db.args[post])
+	}
+	menus := slices.IndexFunc(db.queries, func(q string) bool { return strings.Contains(q, "FROM links") })
+	if menus < 0 || len(db.args[menus]) != 0 {
+		t.Errorf("expected no args for menusections got %v", db.queries)
+	}
+}
+
+func TestPositionalParameters(t *testing.T) {
+	db := &ArgsRior{}
+	eq := exte.ExteQueryr{
+		Db: db,
+		Queries: []exte.Query{
+			exte.Query{Name: "word", SQL: "SELECT * FROM words WHERE lang = $1 AND word = $2 AND note = ':term'"},
+		},
+		Vars: []string{"lang", "term"},
+	}
+	req, err := createRequest("/dictionary/en/cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetPathValue("lang", "en")
+	req.SetPathValue("term", "cat")
+	eq.DoRequestQuery(req)
+	if db.queries[0] != eq.Queries[0].SQL {
+		t.Errorf("expected the query to be unchanged got %v", db.queries[0])
+	}
+	if len(db.args[0]) != 2 || db.args[0][0] != "en" || db.args[0][1] != "cat" {
+		t.Errorf("expected args [en cat] got %v", db.args[0])
+	}
 }
 
 func TestParsing(t *testing.T) {
@@ -303,6 +854,11 @@ func (s SimpleRior) Query(sql string) ante.DataSource {
 	return SimpleDS(s)
 }
 
+// QueryArgs ignores the args, a query that can't bind them would fail.
+func (s SimpleRior) QueryArgs(sql string, args ...any) ante.DataSource {
+	return SimpleDS(s)
+}
+
 type SimpleDS int
 
 func (s SimpleDS) Get(name string) string {
@@ -336,6 +892,38 @@ func (s *SimpleTemplate) FillIn(w io.Writer, ds ante.DataSource) error {
 	return nil
 }
 
+type AnteEngine int
+
+func (AnteEngine) ParseTemplate(r io.Reader) (ante.AnteTemplate, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ante.NewAnteTemplate(string(b)), nil
+}
+
+type FailingTemplate string
+
+func (s FailingTemplate) FillIn(w io.Writer, ds ante.DataSource) error {
+	io.WriteString(w, string(s))
+	return fmt.Errorf("failing template")
+}
+
+// FailingSanitizer fails every data-html.
+type FailingSanitizer int
+
+func (FailingSanitizer) Sanitize(fragment string) (string, error) {
+	return "", errors.New("failing sanitizer")
+}
+
+// ItemFailingTemplate fails in its data-item, like ante does.
+type ItemFailingTemplate string
+
+func (s ItemFailingTemplate) FillIn(w io.Writer, ds ante.DataSource) error {
+	ds.GetDS(string(s))
+	return &ante.ItemError{Item: string(s), Errs: []error{errors.New("failing template")}}
+}
+
 type StaticAnte int
 type StaticTemplate struct {
 	bytes []byte
@@ -407,3 +995,666 @@ func (ar *ArrArrRior) GetDS(name string) ante.DataSource {
 func (ar *ArrArrRior) GetNext() ante.DataSource {
 	return nil
 }
+
+type ArgsRior struct {
+	mu      sync.Mutex
+	queries []string
+	args    [][]any
+}
+
+func (ar *ArgsRior) Query(sql string) ante.DataSource {
+	return ar.QueryArgs(sql)
+}
+
+func (ar *ArgsRior) QueryArgs(sql string, args ...any) ante.DataSource {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.queries = append(ar.queries, sql)
+	ar.args = append(ar.args, args)
+	return SimpleDS(len(ar.queries))
+}
+
+type TableRior struct {
+	mu     sync.Mutex
+	tables map[string][]map[string]string
+	args   [][]any
+}
+
+func (tr *TableRior) Query(sql string) ante.DataSource {
+	return tr.QueryArgs(sql)
+}
+
+func (tr *TableRior) QueryArgs(sql string, args ...any) ante.DataSource {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.args = append(tr.args, args)
+	return &RowsRior{tr.tables[sql], 0}
+}
+
+type RowsRior struct {
+	rows []map[string]string
+	pos  int
+}
+
+func (rr *RowsRior) Get(name string) string {
+	if len(rr.rows) == 0 {
+		return ""
+	}
+	return rr.rows[0][name]
+}
+func (rr *RowsRior) GetDS(name string) ante.DataSource {
+	return nil
+}
+func (rr *RowsRior) Empty() bool {
+	return len(rr.rows) == 0
+}
+func (rr *RowsRior) GetNext() ante.DataSource {
+	if rr.pos >= len(rr.rows) {
+		return nil
+	}
+	rr.pos += 1
+	return &ArrRior{rr.rows[rr.pos-1]}
+}
+
+type ExecRior struct {
+	TableRior
+	execs []string
+}
+
+func (er *ExecRior) Exec(sql string, args ...any) error {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	er.execs = append(er.execs, sql)
+	er.args = append(er.args, args)
+	return nil
+}
+
+func TestLogin(t *testing.T) {
+	hash, err := exte.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &TableRior{tables: map[string][]map[string]string{
+		"SELECT id, name, password, roles FROM users WHERE name = $1": {{"id": "3", "name": "ada", "password": hash, "roles": "author"}},
+		"SELECT title FROM drafts WHERE author = $1":                  {{"title": "Draft"}},
+		"SELECT title FROM posts WHERE id = $1":                       {{"title": "Hello"}},
+	}}
+	handlers, err := exte.CreateHandlers("auth.yaml", db, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handlers.ServeHTTP(rec, req)
+		return rec
+	}
+	post := func(path string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handlers.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/account", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 without a session got %d", rec.Code)
+	}
+	rec := get("/login", nil)
+	anonymous := rec.Result().Cookies()
+	token := csrfToken(rec.Body.String())
+	if len(anonymous) != 1 || anonymous[0].Name != exte.CSRFCookie || token != anonymous[0].Value {
+		t.Fatalf("expected the login form to have the token of its %v cookie got %v %v", exte.CSRFCookie, token, anonymous)
+	}
+	if rec := post("/login", url.Values{"username": {"ada"}, "password": {"secret"}}, anonymous); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a login without the csrf token to be a 403 got %d", rec.Code)
+	}
+	if rec := post("/login", url.Values{"username": {"ada"}, "password": {"wrong"}, exte.CSRFField: {token}}, anonymous); rec.Code != http.StatusForbidden || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("expected a wrong password to be a 403 without a session got %d %v", rec.Code, rec.Result().Cookies())
+	}
+	rec = post("/login", url.Values{"username": {"ada"}, "password": {"secret"}, exte.CSRFField: {token}}, anonymous)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/account" {
+		t.Fatalf("expected a redirect to /account got %d %v", rec.Code, rec.Header().Get("Location"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != exte.SessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("expected a %v cookie got %v", exte.SessionCookie, cookies)
+	}
+
+	rec = get("/account", cookies)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the account with a session got %d", rec.Code)
+	}
+	if got := rec.Body.String(); !strings.Contains(got, ">ada</b>") || !strings.Contains(got, ">Draft</span>") {
+		t.Errorf("expected the user and their drafts got %v", got)
+	}
+	if got := db.args[len(db.args)-1]; len(got) != 1 || got[0] != "3" {
+		t.Errorf("expected :user_id to be bound to 3 got %v", got)
+	}
+	// $1 is the id of the path, not the user or the query string
+	rec = get("/blog/7?q=cats", cookies)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), ">Hello</span>") {
+		t.Errorf("expected the post got %d %v", rec.Code, rec.Body)
+	}
+	if got := db.args[len(db.args)-1]; len(got) != 1 || got[0] != "7" {
+		t.Errorf("expected $1 to be bound to [7] got %v", got)
+	}
+
+	rec = get("/account", cookies)
+	sessionToken := csrfToken(rec.Body.String())
+	if sessionToken == "" || sessionToken == token {
+		t.Errorf("expected the session to have a token of its own got %v", sessionToken)
+	}
+	if rec := get("/admin", cookies); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 without the editor role got %d", rec.Code)
+	}
+
+	if rec := post("/logout", url.Values{exte.CSRFField: {token}}, append(cookies, anonymous...)); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a logout with the token of the cookie instead of the session to be a 403 got %d", rec.Code)
+	}
+	if rec := post("/logout", url.Values{exte.CSRFField: {sessionToken}}, cookies); rec.Code != http.StatusSeeOther {
+		t.Errorf("expected the logout to redirect got %d", rec.Code)
+	}
+	if rec := get("/account", cookies); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a 401 after logging out got %d", rec.Code)
+	}
+}
+
+// NilRior has no data source for any query, and no error either.
+type NilRior int
+
+func (NilRior) Query(sql string) ante.DataSource {
+	return nil
+}
+func (NilRior) QueryErr(ctx context.Context, sql string, args ...any) (ante.DataSource, error) {
+	return nil, nil
+}
+
+func TestLoginWithoutUsers(t *testing.T) {
+	handlers, err := exte.CreateHandlers("auth.yaml", NilRior(1), AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"username": {"ada"}, "password": {"secret"}, exte.CSRFField: {"token"}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: exte.CSRFCookie, Value: "token"})
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected a 403 without users got %d", rec.Code)
+	}
+}
+
+// csrfToken is the value of the first csrf_token field in page, its
+// attributes come out in any order.
+func csrfToken(page string) string {
+	for _, input := range strings.Split(page, "<input")[1:] {
+		input, _, _ = strings.Cut(input, ">")
+		if !strings.Contains(input, "name='csrf_token'") {
+			continue
+		}
+		_, value, _ := strings.Cut(input, " value='")
+		token, _, _ := strings.Cut(value, "'")
+		return token
+	}
+	return ""
+}
+
+func TestRequestDataSource(t *testing.T) {
+	handlers, err := exte.CreateHandlers("request.yaml", &TableRior{}, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/blog/p7.html?q=go+%3Ctips%3E", nil)
+	req.Header.Set("Accept-Language", "fr")
+	req.Header.Set("Authorization", "Basic c2VjcmV0")
+	req.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+	req.AddCookie(&http.Cookie{Name: exte.CSRFCookie, Value: "secret"})
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, req)
+	got := rec.Body.String()
+	for _, want := range []string{">/blog/p7.html</b>", ">7</i>", "value='go &lt;tips&gt;'", ">fr</em>", ">dark</s>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %v in %v", want, got)
+		}
+	}
+	for _, secret := range []string{"c2VjcmV0", ">secret<"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("expected no %v in %v", secret, got)
+		}
+	}
+	if strings.Contains(got, "<time data-field='time'></time>") {
+		t.Errorf("expected the time in %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	handlers.ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), ">/missing</b>") {
+		t.Errorf("expected the 404 page to have the path got %d %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestQueryStringParameters(t *testing.T) {
+	db := &TableRior{}
+	handlers, err := exte.CreateHandlers("search.yaml", db, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		handlers.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code
+	}
+	if got := get("/search?q=go&page=2"); got != http.StatusOK {
+		t.Fatalf("expected a 200 got %d", got)
+	}
+	if got := fmt.Sprint(db.args); got != "[[go 2]]" {
+		t.Errorf("expected q and page to be bound got %v", got)
+	}
+	if _, ok := db.args[0][1].(int64); !ok {
+		t.Errorf("expected page to be bound as an int got %T", db.args[0][1])
+	}
+	get("/search")
+	if got := fmt.Sprint(db.args[1]); got != "[ 1]" {
+		t.Errorf("expected the defaults to be bound got %v", got)
+	}
+	if got := get("/search?page=two"); got != http.StatusBadRequest {
+		t.Errorf("expected a page that isn't an int to be a 400 got %d", got)
+	}
+	if len(db.args) != 2 {
+		t.Errorf("expected no query for a bad page got %v", db.args)
+	}
+}
+
+func TestBadParameterDefault(t *testing.T) {
+	_, err := exte.CreateHandlers("badparams.yaml", &TableRior{}, AnteEngine(1), nil)
+	if err == nil || !strings.Contains(err.Error(), "parameter page") {
+		t.Errorf("expected an error for the default got %v", err)
+	}
+}
+
+func TestBadPagination(t *testing.T) {
+	_, err := exte.CreateHandlers("badpaginate.yaml", &TableRior{}, AnteEngine(1), nil)
+	if err == nil || !strings.Contains(err.Error(), "route /search: query posts: a paginated query can't have a LIMIT") {
+		t.Errorf("expected an error for the LIMIT got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "route /posts: query drafts: only one query") {
+		t.Errorf("expected an error for the second paginated query got %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "route /posts: query posts") {
+		t.Errorf("expected the LIMIT of a subquery to be fine got %v", err)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	db := &TableRior{tables: map[string][]map[string]string{
+		"SELECT title FROM posts ORDER BY id LIMIT 2 OFFSET 2":                             {{"title": "Third"}, {"title": "Fourth"}},
+		"SELECT count(*) AS count FROM (SELECT title FROM posts ORDER BY id) AS paginated": {{"count": "5"}},
+	}}
+	handlers, err := exte.CreateHandlers("paginate.yaml", db, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, httptest.NewRequest("GET", "/posts?page=2&q=x", nil))
+	got := rec.Body.String()
+	for _, want := range []string{
+		">Third</span>", ">Fourth</span>",
+		">2</b>/<b data-field='pages'>3</b>",
+		">1</i></a>", ">3</i></a></span>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %v in %v", want, got)
+		}
+	}
+	// attributes come out in any order
+	if n := strings.Count(got, "href='/posts?page=1&amp;q=x'"); n != 2 {
+		t.Errorf("expected the first page in the pager and prev got %d in %v", n, got)
+	}
+	if n := strings.Count(got, "href='/posts?page=3&amp;q=x'"); n != 2 {
+		t.Errorf("expected the last page in the pager and next got %d in %v", n, got)
+	}
+
+	rec = httptest.NewRecorder()
+	handlers.ServeHTTP(rec, httptest.NewRequest("GET", "/posts?page=first", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a page that isn't a number to be a 400 got %d", rec.Code)
+	}
+}
+
+// BarrierRior holds each query until n of them are running at once, or
+// until release is closed, and remembers how many ran at once.
+type BarrierRior struct {
+	mu      sync.Mutex
+	n       int
+	arrived int
+	release chan struct{}
+	running int
+	most    int
+	closed  chan struct{}
+}
+
+func newBarrierRior(n int) *BarrierRior {
+	return &BarrierRior{n: n, release: make(chan struct{}), closed: make(chan struct{}, 10)}
+}
+
+func (br *BarrierRior) Query(sql string) ante.DataSource {
+	br.mu.Lock()
+	br.running++
+	br.most = max(br.most, br.running)
+	if br.arrived++; br.arrived == br.n {
+		close(br.release)
+	}
+	br.mu.Unlock()
+	<-br.release
+	br.mu.Lock()
+	br.running--
+	br.mu.Unlock()
+	return &ClosingDS{SimpleDS(1), br.closed}
+}
+
+type ClosingDS struct {
+	SimpleDS
+	closed chan struct{}
+}
+
+func (cd *ClosingDS) Close() error {
+	cd.closed <- struct{}{}
+	return nil
+}
+
+func TestQueriesRunConcurrently(t *testing.T) {
+	// the first two queries only return once both are running
+	db := newBarrierRior(2)
+	var queries []exte.Query
+	for _, name := range []string{"post", "sidebar", "comments", "tags"} {
+		queries = append(queries, exte.Query{Name: name, SQL: "SELECT " + name, Columns: []string{"foo"}, Single: true})
+	}
+	eq := &exte.ExteQueryr{Db: db, Queries: queries, Workers: 2}
+	done := make(chan ante.DataSource)
+	go func() { done <- eq.DoQuery() }()
+	var ds ante.DataSource
+	select {
+	case ds = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected two queries to run at once")
+	}
+	if db.most != 2 {
+		t.Errorf("expected 2 queries at once got %d", db.most)
+	}
+	for _, query := range queries {
+		if got := ds.GetDS(query.Name).Get("foo"); got != "1" {
+			t.Errorf("expected %v to have its result got %v", query.Name, got)
+		}
+	}
+}
+
+func TestQueryTimeoutIsA500(t *testing.T) {
+	// the query never has company, it runs until it is released
+	db := newBarrierRior(2)
+	handlers, err := exte.CreateHandlers("timeout.yaml", db, AnteEngine(1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handlers.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected a 500 got %d", rec.Code)
+	}
+	close(db.release)
+	select {
+	case <-db.closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the late result to be closed")
+	}
+}
+
+func TestLazyQueriesRunWhenUsed(t *testing.T) {
+	db := &ArgsRior{}
+	eq := &exte.ExteQueryr{
+		Db: db,
+		Queries: []exte.Query{
+			{Name: "post", SQL: "SELECT title FROM posts", Columns: []string{"foo"}, Single: true},
+			{Name: "sidebar", SQL: "SELECT title FROM links", Columns: []string{"foo"}, Single: true, Lazy: true},
+			{Name: "tags", SQL: "SELECT name FROM tags", Columns: []string{"foo"}, Single: true, Lazy: true},
+		},
+	}
+	ds := eq.DoQuery()
+	if len(db.queries) != 1 {
+		t.Fatalf("expected only the query that isn't lazy to run got %v", db.queries)
+	}
+	first := ds.GetDS("sidebar").Get("foo")
+	if second := ds.GetDS("sidebar").Get("foo"); first != "2" || second != first {
+		t.Errorf("expected the sidebar to be the second query both times got %v %v", first, second)
+	}
+	if len(db.queries) != 2 || db.queries[1] != "SELECT title FROM links" {
+		t.Errorf("expected the sidebar to run once and tags not at all got %v", db.queries)
+	}
+}
+
+func TestLazyQueryWithoutDataSource(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		eq := &exte.ExteQueryr{
+			Db:      NilRior(1),
+			Queries: []exte.Query{{Name: "post", SQL: "SELECT title FROM posts", Columns: []string{"title"}, Single: true, Lazy: lazy}},
+		}
+		post := eq.DoQuery().GetDS("post")
+		if got := post.Get("title"); got != "" {
+			t.Errorf("expected no title when lazy is %v got %v", lazy, got)
+		}
+		if got := post.GetDS("comments"); got == nil {
+			t.Errorf("expected an empty data source when lazy is %v got nil", lazy)
+		}
+	}
+}
+
+type ctxKey struct{}
+
+// ContextRior remembers the context of each query.
+type ContextRior struct {
+	TableRior
+	values []any
+}
+
+func (cr *ContextRior) QueryContext(ctx context.Context, sql string, args ...any) ante.DataSource {
+	cr.mu.Lock()
+	cr.values = append(cr.values, ctx.Value(ctxKey{}))
+	cr.mu.Unlock()
+	return cr.QueryArgs(sql, args...)
+}
+
+func TestRequestContextReachesTheDB(t *testing.T) {
+	db := &ContextRior{TableRior: TableRior{tables: map[string][]map[string]string{
+		"SELECT title FROM posts": {{"title": "Hello"}},
+	}}}
+	eq := &exte.ExteQueryr{
+		Db: db,
+		Queries: []exte.Query{
+			{Name: "post", SQL: "SELECT title FROM posts", Columns: []string{"title"}, Single: true},
+			{Name: "sidebar", SQL: "SELECT title FROM links", Columns: []string{"title"}, Lazy: true},
+		},
+	}
+	tmpl, _ := AnteEngine(1).ParseTemplate(strings.NewReader("<b data-item='post' data-field='title'></b><ul data-item='sidebar'></ul>"))
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, "request"))
+	rec := httptest.NewRecorder()
+	exte.CreateHandler(tmpl, eq)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected a 200 got %d", rec.Code)
+	}
+	if got := fmt.Sprint(db.values); got != "[request request]" {
+		t.Errorf("expected both queries, the lazy one too, to have the request context got %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db.values = nil
+	rec = httptest.NewRecorder()
+	exte.CreateHandler(tmpl, eq)(rec, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if len(db.values) != 0 {
+		t.Errorf("expected no queries once the request is canceled got %v", db.values)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected a 500 for a canceled request got %d", rec.Code)
+	}
+}
+
+// ErrRior fails the queries in fail.
+type ErrRior struct {
+	TableRior
+	fail map[string]error
+}
+
+func (er *ErrRior) QueryErr(ctx context.Context, sql string, args ...any) (ante.DataSource, error) {
+	if err, ok := er.fail[sql]; ok {
+		return nil, err
+	}
+	return er.QueryArgs(sql, args...), nil
+}
+
+// BrokenDS is what a DB without errors returns for a query that failed.
+type BrokenDS struct {
+	SimpleDS
+}
+
+func (BrokenDS) Err() error {
+	return fmt.Errorf("connection refused")
+}
+
+type BrokenRior int
+
+func (BrokenRior) Query(sql string) ante.DataSource {
+	return BrokenDS{}
+}
+
+func TestQueryErrorsAreA500(t *testing.T) {
+	logged := &bytes.Buffer{}
+	log.SetOutput(logged)
+	defer log.SetOutput(os.Stderr)
+	db := &ErrRior{fail: map[string]error{"SELEC title FROM posts": fmt.Errorf("syntax error")}}
+	tmpl, _ := AnteEngine(1).ParseTemplate(strings.NewReader("<b data-item='post'></b><ul data-item='sidebar'></ul>"))
+	for _, query := range []exte.Query{
+		{Name: "post", SQL: "SELEC title FROM posts"},
+		{Name: "post", SQL: "SELEC title FROM posts", Lazy: true},
+	} {
+		eq := &exte.ExteQueryr{Db: db, Queries: []exte.Query{query, {Name: "sidebar", SQL: "SELECT title FROM links", Lazy: true}}}
+		rec := httptest.NewRecorder()
+		exte.CreateHandler(tmpl, eq)(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("expected a 500 got %d", rec.Code)
+		}
+	}
+	if !strings.Contains(logged.String(), "query post: syntax error") {
+		t.Errorf("expected the error to be logged with the name of the query got %v", logged)
+	}
+
+	ds, err := exte.ErrorAdapter(BrokenRior(1)).QueryErr(context.Background(), "SELECT 1")
+	if ds != nil || err == nil || err.Error() != "connection refused" {
+		t.Errorf("expected the adapter to return the error of the data source got %v %v", ds, err)
+	}
+}
+
+// ScanFailRior fails the rows of the queries in fail after they are read.
+type ScanFailRior struct {
+	TableRior
+	fail map[string]bool
+}
+
+func (sr *ScanFailRior) QueryArgs(sql string, args ...any) ante.DataSource {
+	rows := sr.TableRior.QueryArgs(sql, args...).(*RowsRior)
+	if sr.fail[sql] {
+		return &ScanFailDS{rows}
+	}
+	return rows
+}
+
+type ScanFailDS struct {
+	*RowsRior
+}
+
+func (sd *ScanFailDS) Err() error {
+	if sd.pos < len(sd.rows) {
+		return nil
+	}
+	return fmt.Errorf("sql: Scan error on column index 0")
+}
+
+func TestJoinScanErrorsAreA500(t *testing.T) {
+	logged := &bytes.Buffer{}
+	log.SetOutput(logged)
+	defer log.SetOutput(os.Stderr)
+	db := &ScanFailRior{
+		TableRior: TableRior{tables: map[string][]map[string]string{
+			"SELECT id, title FROM posts":                 {{"id": "7", "title": "Hello"}},
+			"SELECT text FROM comments WHERE postid = $1": {{"text": "first"}},
+		}},
+		fail: map[string]bool{"SELECT text FROM comments WHERE postid = $1": true},
+	}
+	eq := &exte.ExteQueryr{
+		Db: db,
+		Queries: []exte.Query{{
+			Name:    "post",
+			SQL:     "SELECT id, title FROM posts",
+			Columns: []string{"id", "title"},
+			Single:  true,
+			Joins: []exte.Joined{{
+				Name:    "comments",
+				SQL:     "SELECT text FROM comments WHERE postid = :id",
+				Columns: []string{"text"},
+			}},
+		}},
+	}
+	tmpl, _ := AnteEngine(1).ParseTemplate(strings.NewReader("<div data-item='post'><ul data-item='comments'><li data-repeating='true'><span data-field='text'></span></li></ul></div>"))
+	rec := httptest.NewRecorder()
+	exte.CreateHandler(tmpl, eq)(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected a 500 got %d", rec.Code)
+	}
+	if !strings.Contains(logged.String(), "query comments: sql: Scan error") {
+		t.Errorf("expected the error to be logged with the name of the join got %v", logged)
+	}
+}
+
+func TestRowThatDoesNotIterate(t *testing.T) {
+	// ArrRior is one row that doesn't iterate, it is its own first row
+	eq := &exte.ExteQueryr{
+		Db:      &ArrRior{map[string]string{"title": "Hello"}},
+		Queries: []exte.Query{{Name: "post", SQL: "post", Columns: []string{"title"}, Single: true, Required: true}},
+	}
+	tmpl, _ := AnteEngine(1).ParseTemplate(strings.NewReader("<p data-if='post' data-item='post'><b data-field='title'></b></p>"))
+	rec := httptest.NewRecorder()
+	exte.CreateHandler(tmpl, eq)(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), ">Hello</b>") {
+		t.Errorf("expected the post to be shown got %d %v", rec.Code, rec.Body.String())
+	}
+}
+
+func TestIfQueryHasRows(t *testing.T) {
+	db := &TableRior{tables: map[string][]map[string]string{
+		"SELECT title FROM posts": {{"title": "Hello"}},
+	}}
+	eq := &exte.ExteQueryr{
+		Db: db,
+		Queries: []exte.Query{
+			{Name: "posts", SQL: "SELECT title FROM posts", Columns: []string{"title"}},
+			{Name: "comments", SQL: "SELECT text FROM comments", Columns: []string{"text"}},
+		},
+	}
+	tmpl, _ := AnteEngine(1).ParseTemplate(strings.NewReader("<ul data-if='posts' data-item='posts'><li data-repeating='true' data-field='title'></li></ul><p data-unless='comments'>no comments yet</p><p data-if='comments'>comments</p><a data-if='user' href='/edit'>edit</a>"))
+	rec := httptest.NewRecorder()
+	exte.CreateHandler(tmpl, eq)(rec, httptest.NewRequest("GET", "/", nil))
+	got := rec.Body.String()
+	if !strings.Contains(got, "no comments yet") || strings.Contains(got, ">comments<") || strings.Contains(got, "edit") {
+		t.Errorf("expected only the no comments paragraph got %v", got)
+	}
+	if strings.Count(got, "<li") != 1 {
+		t.Errorf("expected the posts to be looped over once got %v", got)
+	}
+}
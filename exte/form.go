@@ -0,0 +1,157 @@
+package exte
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	uritemplate "github.com/yosida95/uritemplate/v3"
+
+	"alesgaroth.com/anterior/rior"
+)
+
+// ExecDB is a DB that can run the queries of a form without reading rows.
+type ExecDB interface {
+	DB
+	Exec(query string, args ...any) error
+}
+
+// TxDB is a DB that can run the queries of a form in a transaction.
+type TxDB interface {
+	DB
+	BeginTx(ctx context.Context) (Tx, error)
+}
+
+// Tx is a transaction of a TxDB. It is a rior.Tx so a database can begin
+// one without importing exte.
+type Tx = rior.Tx
+
+// DoFormExec runs the queries, in order, with the fields of the posted form
+// bound to their :name placeholders. The uri template variables and the
+// user come first, a field can't override them. When the DB is a TxDB the
+// queries run in a transaction, which the first one that fails rolls back.
+func (cq *ExteQueryr) DoFormExec(req *http.Request) error {
+	if cq.Db == nil {
+		panic("cq.Db is nil")
+	}
+	params := append(cq.requestParams(req), formParams(req)...)
+	if txdb, ok := cq.Db.(TxDB); ok {
+		return cq.execTx(req.Context(), txdb, params)
+	}
+	for _, query := range cq.Queries {
+		if err := cq.exec(req.Context(), query.SQL, params); err != nil {
+			return fmt.Errorf("query %s: %v", query.Name, err)
+		}
+	}
+	return nil
+}
+
+func (cq *ExteQueryr) execTx(ctx context.Context, txdb TxDB, params []param) error {
+	tx, err := txdb.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	for _, query := range cq.Queries {
+		sql, args := bindParams(query.SQL, params)
+		if err := tx.ExecContext(ctx, sql, args...); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("query %s: %v", query.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func formParams(req *http.Request) []param {
+	var params []param
+	for _, name := range slices.Sorted(maps.Keys(req.PostForm)) {
+		params = append(params, param{name: name, value: req.PostForm.Get(name)})
+	}
+	return params
+}
+
+func (cq *ExteQueryr) exec(ctx context.Context, query string, params []param) error {
+	sql, args := bindParams(query, params)
+	if execdb, ok := cq.Db.(ExecContextDB); ok {
+		return execdb.ExecContext(ctx, sql, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if execdb, ok := cq.Db.(ExecDB); ok {
+		return execdb.Exec(sql, args...)
+	}
+	ds, err := ErrorAdapter(cq.Db).QueryErr(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	for row := ds.GetNext(); row != nil; row = ds.GetNext() {
+	}
+	return dsErr(ds)
+}
+
+func (ed Extedata) method() string {
+	if ed.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(ed.Method)
+}
+
+func (ed Extedata) redirect() (*uritemplate.Template, error) {
+	if ed.Redirect == "" {
+		return nil, nil
+	}
+	return uritemplate.New(ed.Redirect)
+}
+
+// createFormHandler runs the queries of a form and then redirects.
+func createFormHandler(cq *ExteQueryr, redirect *uritemplate.Template, checkCSRF bool, pages statusPages) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			log.Printf("route %s: %v", req.Pattern, err)
+			pages.write(rw, req, http.StatusBadRequest)
+			return
+		}
+		if _, err := cq.queryParams(req); err != nil {
+			log.Printf("route %s: %v", req.Pattern, err)
+			pages.write(rw, req, http.StatusBadRequest)
+			return
+		}
+		if checkCSRF && !validCSRF(req) {
+			log.Printf("route %s: missing or wrong csrf token", req.Pattern)
+			pages.write(rw, req, http.StatusForbidden)
+			return
+		}
+		if err := cq.DoFormExec(req); err != nil {
+			log.Printf("route %s: %v", req.Pattern, err)
+			pages.write(rw, req, http.StatusInternalServerError)
+			return
+		}
+		redirectAfterForm(rw, req, cq, redirect, pages)
+	}
+}
+
+// redirectAfterForm redirects to redirect, expanded with the params of
+// the request and the form, so reloading the page it redirects to doesn't
+// post the form again.
+func redirectAfterForm(rw http.ResponseWriter, req *http.Request, cq *ExteQueryr, redirect *uritemplate.Template, pages statusPages) {
+	location := req.URL.Path
+	if redirect != nil {
+		vars := uritemplate.Values{}
+		for _, p := range append(cq.requestParams(req), formParams(req)...) {
+			if !vars.Get(p.name).Valid() && p.value != nil {
+				vars.Set(p.name, uritemplate.String(fmt.Sprint(p.value)))
+			}
+		}
+		var err error
+		if location, err = redirect.Expand(vars); err != nil {
+			log.Printf("route %s: %v", req.Pattern, err)
+			pages.write(rw, req, http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(rw, req, location, http.StatusSeeOther)
+}
@@ -0,0 +1,220 @@
+package exte
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"alesgaroth.com/anterior/ante"
+)
+
+// rowReader reads the rows of a result, grouping the consecutive rows that
+// only differ in the columns of joins without their own sql, so a post with
+// two authors is one row with an authors join of two rows.
+type rowReader struct {
+	spec    Joined
+	ds      ante.DataSource
+	pending ante.DataSource
+	done    bool
+}
+
+func (rr *rowReader) splits() bool {
+	for _, join := range rr.spec.Joins {
+		if join.SQL == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (rr *rowReader) next() ante.DataSource {
+	if rr.pending != nil {
+		row := rr.pending
+		rr.pending = nil
+		return row
+	}
+	if rr.done || rr.ds == nil {
+		return nil
+	}
+	row := rr.ds.GetNext()
+	if row == nil {
+		rr.done = true
+	}
+	return row
+}
+
+// readGroup returns the rows of the next group, or nil at the end.
+func (rr *rowReader) readGroup() []ante.DataSource {
+	first := rr.next()
+	if first == nil {
+		return nil
+	}
+	group := []ante.DataSource{first}
+	if !rr.splits() {
+		return group
+	}
+	key := rowKey(first, rr.spec.Columns)
+	for row := rr.next(); row != nil; row = rr.next() {
+		if rowKey(row, rr.spec.Columns) != key {
+			rr.pending = row
+			break
+		}
+		group = append(group, row)
+	}
+	return group
+}
+
+func rowKey(row ante.DataSource, cols []string) string {
+	values := make([]string, len(cols))
+	for i, col := range cols {
+		values[i] = row.Get(col)
+	}
+	return strings.Join(values, "\x00")
+}
+
+// rowAdapter is one row of a query or join, restricted to its columns.
+// rows holds every row of the result that belongs to it, which its joins
+// without sql split between them.
+type rowAdapter struct {
+	spec   Joined
+	rows   []ante.DataSource
+	runner *ExteQueryr
+	params []param
+	joined map[string]ante.DataSource
+}
+
+func newRowAdapter(spec Joined, rows []ante.DataSource, runner *ExteQueryr, params []param) *rowAdapter {
+	return &rowAdapter{spec, rows, runner, params, make(map[string]ante.DataSource)}
+}
+
+func (ra *rowAdapter) Get(key string) string {
+	if len(ra.rows) > 0 && slices.Contains(ra.spec.Columns, key) {
+		return ra.rows[0].Get(key)
+	}
+	return ""
+}
+
+// GetDS returns the join called key. Other names fall through to the
+// data source the DB returned, still restricted to the columns.
+func (ra *rowAdapter) GetDS(key string) ante.DataSource {
+	return ra.GetDSContext(context.Background(), key)
+}
+
+// GetDSContext runs a join with its own sql with ctx.
+func (ra *rowAdapter) GetDSContext(ctx context.Context, key string) ante.DataSource {
+	if ds, ok := ra.joined[key]; ok {
+		return ds
+	}
+	var ds ante.DataSource = emptyDS(false)
+	if i := slices.IndexFunc(ra.spec.Joins, func(j Joined) bool { return j.Name == key }); i >= 0 {
+		ds = ra.join(ctx, ra.spec.Joins[i])
+	} else if len(ra.rows) > 0 {
+		if inner := ra.rows[0].GetDS(key); inner != nil {
+			ds = newRowAdapter(Joined{Name: key, Columns: ra.spec.Columns}, []ante.DataSource{inner}, ra.runner, ra.params)
+		}
+	}
+	ra.joined[key] = ds
+	return ds
+}
+
+func (ra *rowAdapter) GetNext() ante.DataSource {
+	return nil
+}
+func (ra *rowAdapter) Empty() bool {
+	return len(ra.rows) == 0
+}
+
+// join runs a join with its own sql as a child query, binding the columns
+// of this row as :name parameters, otherwise it splits this row's rows.
+// The columns come before the params of the request, so a column wins
+// over a uri template variable of the same name.
+func (ra *rowAdapter) join(ctx context.Context, spec Joined) ante.DataSource {
+	if spec.SQL == "" {
+		return &joinAdapter{spec, groupRows(ra.rows, spec.Columns), ra.runner, ra.params, 0, nil}
+	}
+	var params []param
+	for _, col := range ra.spec.Columns {
+		params = append(params, param{name: col, value: ra.Get(col)})
+	}
+	params = append(params, ra.params...)
+	ds := ra.runner.run(ctx, spec.Name, spec.SQL, params)
+	reader := &rowReader{spec: spec, ds: ds}
+	var groups [][]ante.DataSource
+	for group := reader.readGroup(); group != nil; group = reader.readGroup() {
+		groups = append(groups, group)
+	}
+	// the rows can fail part of the way through them too
+	if err := dsErr(ds); err != nil {
+		err = fmt.Errorf("query %s: %w", spec.Name, err)
+		log.Print(err)
+		ra.runner.failures.add(err)
+	}
+	return &joinAdapter{spec, groups, ra.runner, params, 0, nil}
+}
+
+// groupRows groups rows by the values of cols, in the order they are
+// first seen. Rows repeat when a query has more than one join.
+func groupRows(rows []ante.DataSource, cols []string) [][]ante.DataSource {
+	index := make(map[string]int)
+	var groups [][]ante.DataSource
+	for _, row := range rows {
+		key := rowKey(row, cols)
+		if i, ok := index[key]; ok {
+			groups[i] = append(groups[i], row)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, []ante.DataSource{row})
+	}
+	return groups
+}
+
+// joinAdapter is the rows of a join, Get reads the first one.
+type joinAdapter struct {
+	spec   Joined
+	groups [][]ante.DataSource
+	runner *ExteQueryr
+	params []param
+	pos    int
+	head   *rowAdapter
+}
+
+func (ja *joinAdapter) first() *rowAdapter {
+	if ja.head == nil {
+		var rows []ante.DataSource
+		if len(ja.groups) > 0 {
+			rows = ja.groups[0]
+		}
+		ja.head = newRowAdapter(ja.spec, rows, ja.runner, ja.params)
+	}
+	return ja.head
+}
+
+func (ja *joinAdapter) Get(key string) string {
+	return ja.first().Get(key)
+}
+
+func (ja *joinAdapter) GetDS(key string) ante.DataSource {
+	return ja.first().GetDS(key)
+}
+func (ja *joinAdapter) Empty() bool {
+	return len(ja.groups) == 0
+}
+
+func (ja *joinAdapter) GetDSContext(ctx context.Context, key string) ante.DataSource {
+	return ja.first().GetDSContext(ctx, key)
+}
+
+// GetNext returns each row in turn, then nil, and starts over after that
+// so a template can loop over the same join twice.
+func (ja *joinAdapter) GetNext() ante.DataSource {
+	if ja.pos >= len(ja.groups) {
+		ja.pos = 0
+		return nil
+	}
+	group := ja.groups[ja.pos]
+	ja.pos += 1
+	return newRowAdapter(ja.spec, group, ja.runner, ja.params)
+}
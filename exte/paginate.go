@@ -0,0 +1,279 @@
+package exte
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"alesgaroth.com/anterior/ante"
+)
+
+// PaginationKey is the DataSource of the pages of the paginated query of
+// a route. Its Get has page, pages, per_page, total and the urls first,
+// prev, next and last, prev and next are "" when there is no such page.
+// Its GetDS("pages") has a row for every page, with number, url and
+// current, for a pager like
+// <span data-item='pages'><a data-repeating='true' data-attr-href='url'><i data-field='number'></i></a></span>
+const PaginationKey = "pagination"
+
+// Paginate limits a query to the rows of one page, the page is the
+// query string parameter Param, page when empty.
+type Paginate struct {
+	PerPage int    `yaml:"per_page"`
+	Param   string `yaml:"param"`
+}
+
+func (p *Paginate) param() string {
+	if p.Param == "" {
+		return "page"
+	}
+	return p.Param
+}
+
+func (p *Paginate) perPage() int64 {
+	if p.PerPage <= 0 {
+		return 10
+	}
+	return int64(p.PerPage)
+}
+
+// page is the page bound in params, 1 when there is none.
+func (p *Paginate) page(params []param) int64 {
+	value, _ := lookupParam(params, p.param())
+	if page, ok := value.(int64); ok && page > 0 {
+		return page
+	}
+	return 1
+}
+
+// paginated is sql limited to the rows of page.
+func (p *Paginate) paginated(sql string, page int64) string {
+	sql = strings.TrimRight(strings.TrimSpace(sql), ";")
+	return fmt.Sprintf("%s LIMIT %d OFFSET %d", sql, p.perPage(), (page-1)*p.perPage())
+}
+
+var (
+	quotedRe = regexp.MustCompile(`'[^']*'|"[^"]*"`)
+	parensRe = regexp.MustCompile(`\([^()]*\)`)
+	limitRe  = regexp.MustCompile(`(?i)\b(LIMIT|OFFSET|FETCH)\b`)
+)
+
+// hasLimit is whether sql already limits its rows, outside of its
+// subqueries, so adding a LIMIT to it would not be sql.
+func hasLimit(sql string) bool {
+	sql = quotedRe.ReplaceAllString(sql, "''")
+	for parensRe.MatchString(sql) {
+		sql = parensRe.ReplaceAllString(sql, "")
+	}
+	return limitRe.MatchString(sql)
+}
+
+// checkPaginate is an error for a route with more than one paginated
+// query, there is one pagination per route, or with a paginated query
+// that has a LIMIT of its own.
+func (ed Extedata) checkPaginate() error {
+	paginated := 0
+	for _, query := range ed.Queries {
+		if query.Paginate == nil {
+			continue
+		}
+		if paginated++; paginated > 1 {
+			return fmt.Errorf("query %s: only one query of a route can be paginated", query.Name)
+		}
+		if hasLimit(query.SQL) {
+			return fmt.Errorf("query %s: a paginated query can't have a LIMIT, OFFSET or FETCH", query.Name)
+		}
+	}
+	return nil
+}
+
+// pagedSQL is the sql of query, limited to the page in params when it
+// is paginated.
+func (query Query) pagedSQL(params []param) string {
+	if query.Paginate == nil {
+		return query.SQL
+	}
+	return query.Paginate.paginated(query.SQL, query.Paginate.page(params))
+}
+
+func countSQL(sql string) string {
+	sql = strings.TrimRight(strings.TrimSpace(sql), ";")
+	return "SELECT count(*) AS count FROM (" + sql + ") AS paginated"
+}
+
+// params are the query string parameters of the route, with the page of
+// each paginated query as an int when the route doesn't declare it.
+func (ed Extedata) params() []QueryParam {
+	params := ed.Params
+	for _, query := range ed.Queries {
+		if query.Paginate == nil {
+			continue
+		}
+		name := query.Paginate.param()
+		declared := false
+		for _, p := range params {
+			declared = declared || p.Name == name
+		}
+		if !declared {
+			params = append(params, QueryParam{Name: name, Type: "int", Default: "1"})
+		}
+	}
+	return params
+}
+
+type paginator interface {
+	pagination(req *http.Request) ante.DataSource
+}
+
+// pagination is the pagination of the paginated query, or nil.
+func (eds *exteDataSource) pagination(req *http.Request) ante.DataSource {
+	for _, query := range eds.runner.Queries {
+		if query.Paginate == nil {
+			continue
+		}
+		return &paginationDS{
+			paginate: query.Paginate,
+			page:     query.Paginate.page(eds.params),
+			url:      req.URL,
+			count: func() int64 {
+				return eds.runner.count(req.Context(), query.Name, query.SQL, eds.params)
+			},
+		}
+	}
+	return nil
+}
+
+// count is how many rows query has, reading all of the rows of the count
+// so the DB can let go of them.
+func (cq *ExteQueryr) count(ctx context.Context, name string, query string, params []param) int64 {
+	ds := cq.run(ctx, name, countSQL(query), params)
+	row := ds.GetNext()
+	if row == nil {
+		return 0
+	}
+	total, _ := strconv.ParseInt(row.Get("count"), 10, 64)
+	for ds.GetNext() != nil {
+	}
+	return total
+}
+
+// paginationDS is the pages of a query, the rows are counted the first
+// time a template asks for them.
+type paginationDS struct {
+	paginate *Paginate
+	page     int64
+	url      *url.URL
+	count    func() int64
+	rows     int64
+	counted  bool
+}
+
+func (p *paginationDS) total() int64 {
+	if !p.counted {
+		p.rows = p.count()
+		p.counted = true
+	}
+	return p.rows
+}
+
+func (p *paginationDS) pages() int64 {
+	pages := (p.total() + p.paginate.perPage() - 1) / p.paginate.perPage()
+	return max(pages, 1)
+}
+
+func (p *paginationDS) pageURL(page int64) string {
+	query := p.url.Query()
+	query.Set(p.paginate.param(), strconv.FormatInt(page, 10))
+	return (&url.URL{Path: p.url.Path, RawQuery: query.Encode()}).String()
+}
+
+func (p *paginationDS) Get(key string) string {
+	switch key {
+	case "page":
+		return strconv.FormatInt(p.page, 10)
+	case "pages":
+		return strconv.FormatInt(p.pages(), 10)
+	case "per_page":
+		return strconv.FormatInt(p.paginate.perPage(), 10)
+	case "total":
+		return strconv.FormatInt(p.total(), 10)
+	case "url":
+		return p.pageURL(p.page)
+	case "first":
+		return p.pageURL(1)
+	case "last":
+		return p.pageURL(p.pages())
+	case "prev":
+		if p.page <= 1 {
+			return ""
+		}
+		return p.pageURL(min(p.page-1, p.pages()))
+	case "next":
+		if p.page >= p.pages() {
+			return ""
+		}
+		return p.pageURL(p.page + 1)
+	}
+	return ""
+}
+
+func (p *paginationDS) GetDS(key string) ante.DataSource {
+	if key == "pages" {
+		return &pagesDS{p, 0}
+	}
+	return emptyDS(false)
+}
+
+func (p *paginationDS) GetNext() ante.DataSource {
+	return nil
+}
+
+// pagesDS is every page, GetNext starts over after the last one.
+type pagesDS struct {
+	pagination *paginationDS
+	pos        int64
+}
+
+func (ps *pagesDS) Get(key string) string {
+	return ""
+}
+func (ps *pagesDS) GetDS(key string) ante.DataSource {
+	return emptyDS(false)
+}
+func (ps *pagesDS) GetNext() ante.DataSource {
+	if ps.pos >= ps.pagination.pages() {
+		ps.pos = 0
+		return nil
+	}
+	ps.pos += 1
+	return &pageDS{ps.pagination, ps.pos}
+}
+
+type pageDS struct {
+	pagination *paginationDS
+	number     int64
+}
+
+func (pd *pageDS) Get(key string) string {
+	switch key {
+	case "number":
+		return strconv.FormatInt(pd.number, 10)
+	case "url":
+		return pd.pagination.pageURL(pd.number)
+	case "current":
+		if pd.number == pd.pagination.page {
+			return "true"
+		}
+	}
+	return ""
+}
+func (pd *pageDS) GetDS(key string) ante.DataSource {
+	return emptyDS(false)
+}
+func (pd *pageDS) GetNext() ante.DataSource {
+	return nil
+}
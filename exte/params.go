@@ -0,0 +1,99 @@
+package exte
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// QueryParam is a query string parameter a route accepts, bound to the
+// :name placeholders of its queries like the uri template variables.
+//
+//	params:
+//	- name: page
+//	  type: int
+//	  default: "1"
+//	- name: q
+type QueryParam struct {
+	Name string `yaml:"name"`
+	// Type is string, int or bool, string when empty.
+	Type string `yaml:"type"`
+	// Default is bound when the parameter is missing, or NULL when there
+	// is no default, except for a string, which is then "".
+	Default string `yaml:"default"`
+}
+
+// parse converts s to the type of the parameter.
+func (p QueryParam) parse(s string) (any, error) {
+	switch p.Type {
+	case "", "string":
+		return s, nil
+	case "int":
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %q is not an int", p.Name, s)
+		}
+		return n, nil
+	case "bool":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %q is not a bool", p.Name, s)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("parameter %s: unknown type %s", p.Name, p.Type)
+}
+
+func (p QueryParam) defaultValue() any {
+	if p.Default == "" && (p.Type == "" || p.Type == "string") {
+		return ""
+	}
+	if p.Default == "" {
+		return nil
+	}
+	v, _ := p.parse(p.Default)
+	return v
+}
+
+// value is the value of the parameter in req, or its default when it
+// is missing or not of its type, which is also an error.
+func (p QueryParam) value(req *http.Request) (any, error) {
+	query := req.URL.Query()
+	if query.Get(p.Name) == "" {
+		return p.defaultValue(), nil
+	}
+	v, err := p.parse(query.Get(p.Name))
+	if err != nil {
+		return p.defaultValue(), err
+	}
+	return v, nil
+}
+
+// check is an error for a type or default the parameter can't have.
+func (p QueryParam) check() error {
+	switch p.Type {
+	case "", "string", "int", "bool":
+	default:
+		return fmt.Errorf("parameter %s: unknown type %s", p.Name, p.Type)
+	}
+	if p.Default != "" {
+		_, err := p.parse(p.Default)
+		return err
+	}
+	return nil
+}
+
+// queryParams are the parameters of req, with the first that isn't of
+// its type as an error.
+func (cq *ExteQueryr) queryParams(req *http.Request) ([]param, error) {
+	var params []param
+	var first error
+	for _, p := range cq.Params {
+		value, err := p.value(req)
+		if err != nil && first == nil {
+			first = err
+		}
+		params = append(params, param{name: p.Name, value: value})
+	}
+	return params, first
+}
@@ -0,0 +1,129 @@
+package exte
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"alesgaroth.com/anterior/ante"
+)
+
+// RequestKey is the DataSource of the request in a template, as in
+// <a href='/' data-item='request' data-attr-class='path'>
+// Its Get has path, method, host and time, and its GetDS has vars, the
+// uri template variables, query, headers and cookies.
+const RequestKey = "request"
+
+// RequestHeaders are the headers a template can read, the others may have
+// credentials in them.
+var RequestHeaders = []string{"Accept-Language", "Referer", "User-Agent"}
+
+// reservedDS adds the data sources exte makes to the ones of the queries.
+type reservedDS struct {
+	ante.DataSource
+	reserved map[string]ante.DataSource
+}
+
+func (r *reservedDS) GetDS(key string) ante.DataSource {
+	return r.GetDSContext(context.Background(), key)
+}
+func (r *reservedDS) GetDSContext(ctx context.Context, key string) ante.DataSource {
+	if ds, ok := r.reserved[key]; ok {
+		return ds
+	}
+	return ante.GetDS(ctx, r.DataSource, key)
+}
+
+func withReserved(ds ante.DataSource, req *http.Request, vars []string) ante.DataSource {
+	user := userDS{}
+	if s := sessionOf(req); s != nil {
+		user = userDS(s.user)
+	}
+	reserved := map[string]ante.DataSource{
+		UserKey:    user,
+		RequestKey: newRequestDS(req, vars),
+	}
+	if p, ok := ds.(paginator); ok {
+		if pagination := p.pagination(req); pagination != nil {
+			reserved[PaginationKey] = pagination
+		}
+	}
+	return &reservedDS{ds, reserved}
+}
+
+// requestDS is the request a template is filled in for.
+type requestDS struct {
+	req  *http.Request
+	now  time.Time
+	vars valuesDS
+}
+
+func newRequestDS(req *http.Request, vars []string) *requestDS {
+	values := valuesDS{}
+	for _, name := range vars {
+		values[name] = []string{req.PathValue(name)}
+	}
+	return &requestDS{req, time.Now(), values}
+}
+
+func (r *requestDS) Get(key string) string {
+	switch key {
+	case "path":
+		return r.req.URL.Path
+	case "method":
+		return r.req.Method
+	case "host":
+		return r.req.Host
+	case "time":
+		return r.now.Format(time.RFC3339)
+	}
+	return ""
+}
+
+func (r *requestDS) GetDS(key string) ante.DataSource {
+	switch key {
+	case "vars":
+		return r.vars
+	case "query":
+		return valuesDS(r.req.URL.Query())
+	case "headers":
+		headers := valuesDS{}
+		for _, name := range RequestHeaders {
+			if values := r.req.Header.Values(name); len(values) > 0 {
+				headers[name] = values
+			}
+		}
+		return headers
+	case "cookies":
+		cookies := valuesDS{}
+		for _, cookie := range r.req.Cookies() {
+			// exte's own cookies are secrets, the csrf token is csrf_token.
+			if cookie.Name != SessionCookie && cookie.Name != CSRFCookie {
+				cookies[cookie.Name] = append(cookies[cookie.Name], cookie.Value)
+			}
+		}
+		return cookies
+	}
+	return emptyDS(false)
+}
+
+func (r *requestDS) GetNext() ante.DataSource {
+	return nil
+}
+
+// valuesDS is named values, Get reads the first value of a name.
+type valuesDS url.Values
+
+func (v valuesDS) Get(key string) string {
+	return url.Values(v).Get(key)
+}
+func (v valuesDS) GetDS(key string) ante.DataSource {
+	return emptyDS(false)
+}
+func (v valuesDS) GetNext() ante.DataSource {
+	return nil
+}
+func (v valuesDS) Empty() bool {
+	return len(v) == 0
+}
@@ -0,0 +1,197 @@
+package exte
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	uritemplate "github.com/yosida95/uritemplate/v3"
+)
+
+// router finds the route for a whole path, segment by segment. A literal
+// segment wins over a segment with a variable in it, which wins over a
+// segment that is only a variable, whatever order the routes are in.
+type router struct {
+	root *routeNode
+	// others are the entries that can't be split into segments, like the
+	// ones from plugins, matched by their regexp in order.
+	others []HandlerEntry
+}
+
+type routeNode struct {
+	literals map[string]*routeNode
+	patterns []*patternNode
+	entries  map[string]*HandlerEntry
+}
+
+// patternNode is a segment with variables in it.
+type patternNode struct {
+	shape    string
+	re       *regexp.Regexp
+	literals int
+	node     *routeNode
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{literals: make(map[string]*routeNode), entries: make(map[string]*HandlerEntry)}
+}
+
+func newRouter() *router {
+	return &router{root: newRouteNode()}
+}
+
+var expressionRe = regexp.MustCompile(`\{[^}]*\}`)
+
+// segmentable is whether the expressions of path only ever match
+// inside one segment.
+func segmentable(path string) bool {
+	for _, expr := range expressionRe.FindAllString(path, -1) {
+		if strings.ContainsAny(expr, "+#./;?&*") {
+			return false
+		}
+	}
+	return true
+}
+
+// shape is the pattern of entry without the names of its variables, two
+// entries with the same shape match the same paths.
+func (entry HandlerEntry) shape() string {
+	if entry.tmpl == nil {
+		return entry.re.String()
+	}
+	return expressionRe.ReplaceAllStringFunc(entry.tmpl.Raw(), func(expr string) string {
+		inner := expr[1 : len(expr)-1]
+		op := ""
+		if inner != "" && strings.ContainsRune("+#./;?&", rune(inner[0])) {
+			op, inner = inner[:1], inner[1:]
+		}
+		vars := strings.Split(inner, ",")
+		for i, v := range vars {
+			vars[i] = ""
+			if strings.HasSuffix(v, "*") {
+				vars[i] = "*"
+			} else if colon := strings.IndexByte(v, ':'); colon >= 0 {
+				vars[i] = v[colon:]
+			}
+		}
+		return "{" + op + strings.Join(vars, ",") + "}"
+	})
+}
+
+// add adds entry, it is an error for two routes to match the same paths.
+func (r *router) add(entry HandlerEntry) error {
+	if entry.tmpl == nil || !segmentable(entry.tmpl.Raw()) {
+		for _, other := range r.others {
+			if other.method == entry.method && other.shape() == entry.shape() {
+				return fmt.Errorf("route %s %s conflicts with route %s", entry.method, entry.pattern(), other.pattern())
+			}
+		}
+		r.others = append(r.others, entry)
+		return nil
+	}
+	node := r.root
+	for _, segment := range strings.Split(entry.tmpl.Raw(), "/") {
+		var err error
+		if node, err = node.child(segment); err != nil {
+			return err
+		}
+	}
+	if other, ok := node.entries[entry.method]; ok {
+		return fmt.Errorf("route %s %s conflicts with route %s", entry.method, entry.tmpl.Raw(), other.tmpl.Raw())
+	}
+	node.entries[entry.method] = &entry
+	return nil
+}
+
+func (n *routeNode) child(segment string) (*routeNode, error) {
+	if !strings.Contains(segment, "{") {
+		if _, ok := n.literals[segment]; !ok {
+			n.literals[segment] = newRouteNode()
+		}
+		return n.literals[segment], nil
+	}
+	shape := expressionRe.ReplaceAllString(segment, "{}")
+	for _, pattern := range n.patterns {
+		if pattern.shape == shape {
+			return pattern.node, nil
+		}
+	}
+	tmpl, err := uritemplate.New(segment)
+	if err != nil {
+		return nil, err
+	}
+	pattern := &patternNode{shape, tmpl.Regexp(), len(expressionRe.ReplaceAllString(segment, "")), newRouteNode()}
+	n.patterns = append(n.patterns, pattern)
+	slices.SortStableFunc(n.patterns, func(a, b *patternNode) int {
+		if a.literals != b.literals {
+			return b.literals - a.literals
+		}
+		return strings.Compare(a.shape, b.shape)
+	})
+	return pattern.node, nil
+}
+
+// find returns the most specific route for path and method, or when no
+// route for path has an entry for method, the methods they have.
+func (r *router) find(method string, path string) (HandlerEntry, []string, bool) {
+	allowed := make(map[string]bool)
+	if entry := r.root.find(strings.Split(path, "/"), method, allowed); entry != nil {
+		return *entry, nil, true
+	}
+	for _, entry := range r.others {
+		if !entry.re.MatchString(path) {
+			continue
+		}
+		if entry.method == "" || entry.method == method {
+			return entry, nil, true
+		}
+		allowed[entry.method] = true
+	}
+	return HandlerEntry{}, slices.Sorted(maps.Keys(allowed)), false
+}
+
+// entry is the entry for method, the GET entry also answers HEAD.
+func (n *routeNode) entry(method string) (*HandlerEntry, bool) {
+	if entry, ok := n.entries[method]; ok {
+		return entry, true
+	}
+	if entry, ok := n.entries[""]; ok {
+		return entry, true
+	}
+	if method == http.MethodHead {
+		entry, ok := n.entries[http.MethodGet]
+		return entry, ok
+	}
+	return nil, false
+}
+
+// find returns the entry for method of the most specific node for
+// segments that has one, adding the methods of the nodes without one
+// to allowed.
+func (n *routeNode) find(segments []string, method string, allowed map[string]bool) *HandlerEntry {
+	if len(segments) == 0 {
+		if entry, ok := n.entry(method); ok {
+			return entry
+		}
+		for m := range n.entries {
+			allowed[m] = true
+		}
+		return nil
+	}
+	if child, ok := n.literals[segments[0]]; ok {
+		if entry := child.find(segments[1:], method, allowed); entry != nil {
+			return entry
+		}
+	}
+	for _, pattern := range n.patterns {
+		if pattern.re.MatchString(segments[0]) {
+			if entry := pattern.node.find(segments[1:], method, allowed); entry != nil {
+				return entry
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package exte
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"alesgaroth.com/anterior/ante"
+	uritemplate "github.com/yosida95/uritemplate/v3"
+)
+
+const (
+	// SessionCookie holds the session of a logged in user.
+	SessionCookie = "exte_session"
+	// SessionLifetime is how long a session lasts after its last request.
+	SessionLifetime = 24 * time.Hour
+	// UserKey is the DataSource of the logged in user in a template, its
+	// columns are bound to :user_<column> placeholders in queries.
+	UserKey = "user"
+)
+
+// Login is how a login route finds the user for the posted username
+// and password. Its SQL binds the fields of the form, like :username.
+type Login struct {
+	SQL string `yaml:"sql"`
+	// Columns are the columns of the user, for templates and queries.
+	Columns []string `yaml:"columns"`
+	// PasswordColumn has a hash from HashPassword, password when empty.
+	PasswordColumn string `yaml:"password_column"`
+	// RolesColumn has the roles of the user separated by commas, roles
+	// when empty.
+	RolesColumn string `yaml:"roles_column"`
+}
+
+func (l *Login) passwordColumn() string {
+	if l.PasswordColumn == "" {
+		return "password"
+	}
+	return l.PasswordColumn
+}
+
+func (l *Login) rolesColumn() string {
+	if l.RolesColumn == "" {
+		return "roles"
+	}
+	return l.RolesColumn
+}
+
+type session struct {
+	user  map[string]string
+	roles []string
+	// csrf is the csrf token of the session, see validCSRF.
+	csrf    string
+	expires time.Time
+}
+
+// sessionStore keeps the sessions in memory, they don't outlive the server.
+// The expired ones are dropped when they are asked for, and when a
+// session starts.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*session)}
+}
+
+func (ss *sessionStore) get(req *http.Request) *session {
+	cookie, err := req.Cookie(SessionCookie)
+	if err != nil {
+		return nil
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, ok := ss.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(s.expires) {
+		delete(ss.sessions, cookie.Value)
+		return nil
+	}
+	s.expires = time.Now().Add(SessionLifetime)
+	return s
+}
+
+func (ss *sessionStore) start(rw http.ResponseWriter, req *http.Request, s *session) {
+	ss.end(rw, req)
+	id := newCSRFToken()
+	s.csrf = newCSRFToken()
+	s.expires = time.Now().Add(SessionLifetime)
+	ss.mu.Lock()
+	maps.DeleteFunc(ss.sessions, func(_ string, other *session) bool { return time.Now().After(other.expires) })
+	ss.sessions[id] = s
+	ss.mu.Unlock()
+	http.SetCookie(rw, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (ss *sessionStore) end(rw http.ResponseWriter, req *http.Request) {
+	cookie, err := req.Cookie(SessionCookie)
+	if err != nil {
+		return
+	}
+	ss.mu.Lock()
+	delete(ss.sessions, cookie.Value)
+	ss.mu.Unlock()
+	http.SetCookie(rw, &http.Cookie{Name: SessionCookie, Value: "", Path: "/", MaxAge: -1})
+}
+
+type sessionKey struct{}
+
+// withSession puts the session of req, if it has one, in its context.
+func (ss *sessionStore) withSession(req *http.Request) *http.Request {
+	if s := ss.get(req); s != nil {
+		return req.WithContext(context.WithValue(req.Context(), sessionKey{}, s))
+	}
+	return req
+}
+
+func sessionOf(req *http.Request) *session {
+	s, _ := req.Context().Value(sessionKey{}).(*session)
+	return s
+}
+
+// userParams are the columns of the logged in user as user_<column>.
+func userParams(req *http.Request) []param {
+	s := sessionOf(req)
+	if s == nil {
+		return nil
+	}
+	var params []param
+	for _, col := range slices.Sorted(maps.Keys(s.user)) {
+		params = append(params, param{name: "user_" + col, value: s.user[col]})
+	}
+	return params
+}
+
+// userDS is the logged in user, or nobody, in a template.
+type userDS map[string]string
+
+func (u userDS) Get(key string) string {
+	return u[key]
+}
+func (userDS) GetDS(key string) ante.DataSource {
+	return emptyDS(false)
+}
+func (userDS) GetNext() ante.DataSource {
+	return nil
+}
+func (u userDS) Empty() bool {
+	return len(u) == 0
+}
+
+func (ed Extedata) needsAuth() bool {
+	return ed.Auth == "required" || len(ed.Roles) > 0
+}
+
+// requireAuth answers a 401 to anyone not logged in, and a 403 to
+// users without one of the roles.
+func requireAuth(handler http.HandlerFunc, roles []string, pages statusPages) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		s := sessionOf(req)
+		if s == nil {
+			pages.write(rw, req, http.StatusUnauthorized)
+			return
+		}
+		if len(roles) > 0 && !slices.ContainsFunc(roles, func(role string) bool { return slices.Contains(s.roles, role) }) {
+			log.Printf("route %s: user without roles %v", req.Pattern, roles)
+			pages.write(rw, req, http.StatusForbidden)
+			return
+		}
+		handler(rw, req)
+	}
+}
+
+// createLoginHandler starts a session for the user the login query finds
+// with the posted password, then redirects.
+func createLoginHandler(cq *ExteQueryr, login *Login, store *sessionStore, redirect *uritemplate.Template, checkCSRF bool, pages statusPages) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			pages.write(rw, req, http.StatusBadRequest)
+			return
+		}
+		if checkCSRF && !validCSRF(req) {
+			log.Printf("route %s: missing or wrong csrf token", req.Pattern)
+			pages.write(rw, req, http.StatusForbidden)
+			return
+		}
+		params := append(cq.requestParams(req), formParams(req)...)
+		users, err := cq.query(req.Context(), "login", login.SQL, params)
+		if err != nil {
+			log.Printf("route %s: %v", req.Pattern, err)
+			pages.write(rw, req, http.StatusInternalServerError)
+			return
+		}
+		var row ante.DataSource
+		if users != nil {
+			row = users.GetNext()
+		}
+		hash := ""
+		if row != nil {
+			hash = row.Get(login.passwordColumn())
+		}
+		if !CheckPassword(req.PostForm.Get("password"), hash) {
+			log.Printf("route %s: login failed", req.Pattern)
+			pages.write(rw, req, http.StatusForbidden)
+			return
+		}
+		user := make(map[string]string)
+		for _, col := range login.Columns {
+			user[col] = row.Get(col)
+		}
+		var roles []string
+		for _, role := range strings.Split(row.Get(login.rolesColumn()), ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+		store.start(rw, req, &session{user: user, roles: roles})
+		redirectAfterForm(rw, req, cq, redirect, pages)
+	}
+}
+
+// createLogoutHandler ends the session, then redirects.
+func createLogoutHandler(cq *ExteQueryr, store *sessionStore, redirect *uritemplate.Template, checkCSRF bool, pages statusPages) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			pages.write(rw, req, http.StatusBadRequest)
+			return
+		}
+		if checkCSRF && !validCSRF(req) {
+			log.Printf("route %s: missing or wrong csrf token", req.Pattern)
+			pages.write(rw, req, http.StatusForbidden)
+			return
+		}
+		store.end(rw, req)
+		redirectAfterForm(rw, req, cq, redirect, pages)
+	}
+}
+
+const passwordIterations = 600000
+
+// HashPassword hashes password for the password column of the users table.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// dummyHash is checked when there is no user, so a missing user takes as
+// long as a wrong password.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("")
+	return hash
+})
+
+// CheckPassword is whether password is the one hash was made from.
+func CheckPassword(password string, hash string) bool {
+	valid := hash != ""
+	if !valid {
+		hash = dummyHash()
+	}
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, expected) == 1 && valid
+}
@@ -0,0 +1,82 @@
+package exte
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+
+	"alesgaroth.com/anterior/ante"
+)
+
+// statusPage is the page for an error status, declared in the yaml as an
+// entry with a status instead of a path.
+type statusPage struct {
+	template ante.AnteTemplate
+	q        Queryr
+}
+
+type statusPages map[int]*statusPage
+
+// with returns pages with page as the page for status.
+func (pages statusPages) with(status int, page *statusPage) statusPages {
+	withPage := maps.Clone(pages)
+	if withPage == nil {
+		withPage = make(statusPages)
+	}
+	withPage[status] = page
+	return withPage
+}
+
+// write renders the page for status, falling back to plain text
+// when there is no page or it fails too.
+func (pages statusPages) write(rw http.ResponseWriter, req *http.Request, status int) {
+	page, ok := pages[status]
+	if !ok {
+		http.Error(rw, http.StatusText(status), status)
+		return
+	}
+	// the page says the request failed, even when that is because it ran out of time
+	req = req.WithContext(context.WithoutCancel(req.Context()))
+	var ds ante.DataSource = emptyDS(false)
+	if page.q != nil {
+		ds = doQuery(page.q, req)
+	}
+	buf := &bytes.Buffer{}
+	if err := ante.FillIn(req.Context(), page.template, buf, &statusDS{status, withReserved(ds, req, nil)}); err != nil {
+		log.Printf("status page %d: %v", status, err)
+		http.Error(rw, http.StatusText(status), status)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(status)
+	rw.Write(buf.Bytes())
+}
+
+// statusDS is what a status page is filled in with, its status and
+// message, and the results of its queries.
+type statusDS struct {
+	status int
+	ds     ante.DataSource
+}
+
+func (s *statusDS) Get(key string) string {
+	switch key {
+	case "status":
+		return strconv.Itoa(s.status)
+	case "message":
+		return http.StatusText(s.status)
+	}
+	return s.ds.Get(key)
+}
+func (s *statusDS) GetDS(key string) ante.DataSource {
+	return s.ds.GetDS(key)
+}
+func (s *statusDS) GetDSContext(ctx context.Context, key string) ante.DataSource {
+	return ante.GetDS(ctx, s.ds, key)
+}
+func (s *statusDS) GetNext() ante.DataSource {
+	return nil
+}
@@ -1,6 +1,16 @@
 package rior
 
+import "context"
+
 type Rior interface {
 	Get(name string) string
 	GetDS(name string) Rior
 }
+
+// Tx is a transaction, either all of its queries are made, with Commit,
+// or none are, with Rollback.
+type Tx interface {
+	ExecContext(ctx context.Context, query string, args ...any) error
+	Commit() error
+	Rollback() error
+}
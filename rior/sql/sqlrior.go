@@ -1,10 +1,19 @@
-package main
+// Package sqlrior reads the DataSources for exte out of a database/sql database.
+package sqlrior
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	_ "github.com/lib/pq"
 	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"alesgaroth.com/anterior/ante"
 	"alesgaroth.com/anterior/rior"
 )
 
@@ -22,125 +31,398 @@ func (rsc *RiorSqlConnection) Ping() error {
 
 func (rsc *RiorSqlConnection) Query1(query string) (rior.Rior, error) {
 	rows, err := rsc.db.Query(query)
-	if err == nil {
-		defer rows.Close()
-		rows.Next()
-		columns, err := rows.Columns()
-		if err == nil {
-			num := len(columns)
-			arr := make([]any, 0)
-			arr2 := make([]*string, 0)
-			for k := 0; k < num; k += 1 {
-				var name string
-				arr = append(arr, &name)
-				arr2 = append(arr2, &name)
-			}
-			err = rows.Scan(arr...)
-			if err == nil {
-				data  := make(map[string]string)
-				for k, colname := range columns {
-					data[colname] = *arr2[k]
-				}
-				return &SQL1Rior{data}, nil
-			} else {
-				return nil, fmt.Errorf("yo1", err)
-			}
-		} else {
-			return nil, fmt.Errorf("yo2", err)
+	if err != nil {
+		return nil, fmt.Errorf("query %v", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("columns %v", err)
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("next %v", err)
 		}
-	} else {
-		return nil, fmt.Errorf("yo3 %v", err)
+		return &SQL1Rior{map[string]string{}, nil}, nil
+	}
+	data, nulls, err := scanRow(rows, columns)
+	if err != nil {
+		return nil, fmt.Errorf("scan %v", err)
+	}
+	return &SQL1Rior{data, nulls}, nil
+}
+
+// Query runs query and returns its rows, it is how a RiorSqlConnection
+// is an exte.DB. An error is logged and leaves no rows, see QueryRows.
+func (rsc *RiorSqlConnection) Query(query string) ante.DataSource {
+	return rsc.QueryArgs(query)
+}
+
+// QueryArgs is Query with args bound to the $n placeholders of query.
+func (rsc *RiorSqlConnection) QueryArgs(query string, args ...any) ante.DataSource {
+	return rsc.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext is QueryArgs stopped when ctx is done, it is how a
+// RiorSqlConnection is an exte.ContextDB.
+func (rsc *RiorSqlConnection) QueryContext(ctx context.Context, query string, args ...any) ante.DataSource {
+	rows, err := rsc.QueryRowsContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("query %v", err)
+		return &SQLRior{done: true, err: err}
+	}
+	return rows
+}
+
+// QueryErr is QueryContext with the error, it is how a
+// RiorSqlConnection is an exte.ErrorDB.
+func (rsc *RiorSqlConnection) QueryErr(ctx context.Context, query string, args ...any) (ante.DataSource, error) {
+	rows, err := rsc.QueryRowsContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Exec runs a query that changes the database, it is how a
+// RiorSqlConnection is an exte.ExecDB.
+func (rsc *RiorSqlConnection) Exec(query string, args ...any) error {
+	return rsc.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext is Exec stopped when ctx is done.
+func (rsc *RiorSqlConnection) ExecContext(ctx context.Context, query string, args ...any) error {
+	_, err := rsc.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// BeginTx starts a transaction, it is how a RiorSqlConnection is an
+// exte.TxDB.
+func (rsc *RiorSqlConnection) BeginTx(ctx context.Context) (rior.Tx, error) {
+	tx, err := rsc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
 	}
-	return nil, err
+	return sqlTx{tx}, nil
+}
+
+// sqlTx is a transaction for the queries of a form.
+type sqlTx struct {
+	tx *sql.Tx
+}
+
+func (st sqlTx) ExecContext(ctx context.Context, query string, args ...any) error {
+	_, err := st.tx.ExecContext(ctx, query, args...)
+	return err
+}
+func (st sqlTx) Commit() error {
+	return st.tx.Commit()
+}
+func (st sqlTx) Rollback() error {
+	return st.tx.Rollback()
+}
+
+// QueryRows runs query with args and returns its rows.
+func (rsc *RiorSqlConnection) QueryRows(query string, args ...any) (*SQLRior, error) {
+	return rsc.QueryRowsContext(context.Background(), query, args...)
 }
-func (rsc *RiorSqlConnection) Query(query string) (rior.Rior, error) {
 
-	var err error
-	if rows, err := rsc.db.Query(query); err != nil {
-		if columns, err := rows.Columns(); err != nil {
-			return &SQLRior{rows, columns}, nil
+// QueryRowsContext is QueryRows stopped when ctx is done, which also
+// closes the rows.
+func (rsc *RiorSqlConnection) QueryRowsContext(ctx context.Context, query string, args ...any) (*SQLRior, error) {
+	rows, err := rsc.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return &SQLRior{rows: rows, columns: columns}, nil
+}
+
+// scanRow reads the current row as strings, NULL columns are "" and are
+// also listed in nulls.
+func scanRow(rows *sql.Rows, columns []string) (map[string]string, map[string]bool, error) {
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for k := range values {
+		dest[k] = &values[k]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, nil, err
+	}
+	data := make(map[string]string)
+	nulls := make(map[string]bool)
+	for k, colname := range columns {
+		if values[k] == nil {
+			nulls[colname] = true
 		}
+		data[colname] = formatValue(values[k])
+	}
+	return data, nulls, nil
+}
+
+// TimeFormat is how time columns are formatted.
+const TimeFormat = time.RFC3339
+
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(TimeFormat)
 	}
-	return nil, err
+	return fmt.Sprint(value)
 }
 
 type SQL1Rior struct {
-	data map[string]string
+	data  map[string]string
+	nulls map[string]bool
 }
+
 func (sr *SQL1Rior) Get(name string) string {
 	if dit, ok := sr.data[name]; ok {
 		return dit
 	}
 	return ""
 }
+
+// GetDS has the NULL columns of the row as NullKey, like the rows of an SQLRior.
 func (sr *SQL1Rior) GetDS(name string) rior.Rior {
-	return nil
+	if name != NullKey {
+		return nil
+	}
+	nulls := make(map[string]string)
+	for col, null := range sr.nulls {
+		if null {
+			nulls[col] = "true"
+		}
+	}
+	return &SQL1Rior{nulls, nil}
+}
+
+// IsNull tells a NULL column from an empty one.
+func (sr *SQL1Rior) IsNull(name string) bool {
+	return sr.nulls[name]
 }
 
+// SQLRior is the rows of a query. GetNext returns each row in turn,
+// Get reads the first row. The rows are closed after the last one,
+// or by an error, which Err reports.
 type SQLRior struct {
-	rows *sql.Rows
+	rows    *sql.Rows
 	columns []string
+	first   *sqlRow
+	pending *sqlRow
+	done    bool
+	err     error
 }
+
+func (sr *SQLRior) scan() *sqlRow {
+	if sr.done {
+		return nil
+	}
+	if !sr.rows.Next() {
+		sr.err = sr.rows.Err()
+		sr.Close()
+		return nil
+	}
+	data, nulls, err := scanRow(sr.rows, sr.columns)
+	if err != nil {
+		sr.err = err
+		sr.Close()
+		return nil
+	}
+	row := &sqlRow{data, nulls}
+	if sr.first == nil {
+		sr.first = row
+	}
+	return row
+}
+
 func (sr *SQLRior) Get(name string) string {
-	return ""
+	if sr.first == nil && sr.pending == nil {
+		sr.pending = sr.scan()
+	}
+	if sr.first == nil {
+		return ""
+	}
+	return sr.first.Get(name)
+}
+func (sr *SQLRior) GetDS(name string) ante.DataSource {
+	if sr.Get(name); sr.first == nil {
+		return nil
+	}
+	return sr.first.GetDS(name)
 }
-func (sr *SQLRior) GetDS(name string) rior.Rior {
+func (sr *SQLRior) GetNext() ante.DataSource {
+	if sr.pending != nil {
+		row := sr.pending
+		sr.pending = nil
+		return row
+	}
+	if row := sr.scan(); row != nil {
+		return row
+	}
 	return nil
 }
 
+// Empty is whether the query has no rows.
+func (sr *SQLRior) Empty() bool {
+	if sr.first == nil && sr.pending == nil {
+		sr.pending = sr.scan()
+	}
+	return sr.first == nil
+}
 
-func NewRiorSqlConnection(username, hostname, database string) (*RiorSqlConnection, error) {
-	connectionString := fmt.Sprintf("postgres://%s:%s@%s:5432/%s?sslmode=disable", username, username, hostname, database)
-	db, err := sql.Open("postgres", connectionString)
-	if err != nil {
-		return nil, err
+// Err is the error that stopped the rows early, if any.
+func (sr *SQLRior) Err() error {
+	return sr.err
+}
+
+// Close closes the rows before the last one is read.
+func (sr *SQLRior) Close() error {
+	sr.done = true
+	if sr.rows == nil {
+		return nil
 	}
-	return &RiorSqlConnection{db}, nil
+	return sr.rows.Close()
 }
 
-func main() {
-	sqlConn, err := NewRiorSqlConnection("alesgaroth", "localhost", "weblog", )
+// NullKey is the DataSource of a row that says which columns are NULL,
+// its Get returns "true" for them, so a template can use
+// <span data-item='null'><span data-field='published_at'></span></span>
+const NullKey = "null"
 
+type sqlRow struct {
+	data  map[string]string
+	nulls map[string]bool
+}
 
-	if err != nil {
-		log.Fatal(err)
+func (sr *sqlRow) Get(name string) string {
+	return sr.data[name]
+}
+func (sr *sqlRow) GetDS(name string) ante.DataSource {
+	if name == NullKey {
+		return nullsDS(sr.nulls)
 	}
-	defer sqlConn.Close()
+	return nil
+}
+func (sr *sqlRow) GetNext() ante.DataSource {
+	return nil
+}
 
-	err = sqlConn.Ping()
-	if err != nil {
-		log.Fatal(err)
+// IsNull tells a NULL column from an empty one.
+func (sr *sqlRow) IsNull(name string) bool {
+	return sr.nulls[name]
+}
+
+type nullsDS map[string]bool
+
+func (nd nullsDS) Get(name string) string {
+	if nd[name] {
+		return "true"
+	}
+	return ""
+}
+func (nullsDS) GetDS(name string) ante.DataSource {
+	return nil
+}
+func (nullsDS) GetNext() ante.DataSource {
+	return nil
+}
+
+// Config is how to connect to a database.
+type Config struct {
+	// Driver is the database/sql driver, postgres when empty.
+	Driver string
+	// DSN is the whole data source name for the driver, when it is set
+	// the fields after it are not used to build one.
+	DSN        string
+	User       string
+	Password   string
+	Host       string
+	Port       int
+	Database   string
+	SSLMode    string
+	SearchPath string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func (c Config) driver() string {
+	if c.Driver == "" {
+		return "postgres"
 	}
-	fmt.Println("Successfully connected to PostgreSQL!")
+	return c.Driver
+}
 
-  var ds rior.Rior
-	ds, err = sqlConn.Query1("SELECT * FROM post")
-	if err != nil {
-		log.Fatal(err)
+// dataSourceName builds a postgres url from the fields of c, unless c has a DSN.
+func (c Config) dataSourceName() string {
+	if c.DSN != "" {
+		return c.DSN
 	}
-	if ds == nil {
-		log.Fatal("oops")
+	u := &url.URL{Scheme: "postgres", Host: c.Host, Path: "/" + c.Database}
+	if c.Port != 0 {
+		u.Host = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
 	}
-	fmt.Printf("id : %v title %v body %v\n", ds.Get("id"), ds.Get("title"), ds.Get("body"))
+	if c.Password != "" {
+		u.User = url.UserPassword(c.User, c.Password)
+	} else if c.User != "" {
+		u.User = url.User(c.User)
+	}
+	query := url.Values{}
+	if c.SSLMode != "" {
+		query.Set("sslmode", c.SSLMode)
+	}
+	if c.SearchPath != "" {
+		query.Set("search_path", c.SearchPath)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
 
-	db := sqlConn.db
-	rows, err := db.Query("SELECT * FROM post")
+// Open connects to the database of config.
+func Open(config Config) (*RiorSqlConnection, error) {
+	db, err := sql.Open(config.driver(), config.dataSourceName())
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer rows.Close() // Process query results
-	for rows.Next() {
-		var id int
-		var title string
-		var body string
-		// Process each row
-
-		err := rows.Scan(&id, &title, &body)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		fmt.Printf("id : %d title %s body %s\n", id, title, body)
-
+		return nil, err
 	}
+	if config.MaxOpenConns != 0 {
+		db.SetMaxOpenConns(config.MaxOpenConns)
+	}
+	if config.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	if config.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	}
+	return &RiorSqlConnection{db}, nil
+}
+
+// NewRiorSqlConnection connects to a local postgres database as username,
+// using username as the password too. Use Open for anything else.
+func NewRiorSqlConnection(username, hostname, database string) (*RiorSqlConnection, error) {
+	return Open(Config{
+		User:     username,
+		Password: username,
+		Host:     hostname,
+		Port:     5432,
+		Database: database,
+		SSLMode:  "disable",
+	})
 }